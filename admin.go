@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// adminToken, when set, must be presented as a bearer token to reach any
+// admin endpoint.
+var adminToken = os.Getenv("ADMIN_TOKEN")
+
+// adminAuth rejects requests without the configured admin token, letting
+// every request through when no token is configured. All requests are
+// recorded in the audit log.
+func adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := "anonymous"
+		if adminToken != "" {
+			auth := r.Header.Get("Authorization")
+			token := strings.TrimPrefix(auth, "Bearer ")
+			if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				audit(r, user, http.StatusUnauthorized)
+				return
+			}
+			user = "admin-token"
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+		audit(r, user, rec.status)
+	}
+}
+
+type adminTarget struct {
+	Endpoint    string  `json:"endpoint"`
+	State       string  `json:"state"`
+	SuccessRate float64 `json:"success_rate"`
+	Samples     int     `json:"samples"`
+	targetStats
+}
+
+// adminTargetsHandler lists every ping target with its current state and
+// recent success rate.
+func adminTargetsHandler(w http.ResponseWriter, r *http.Request) {
+	out := []adminTarget{}
+	for _, p := range targets.Snapshot() {
+		rate, n := p.SuccessRate()
+		out = append(out, adminTarget{
+			Endpoint:    p.endpoint,
+			State:       p.State().String(),
+			SuccessRate: rate,
+			Samples:     n,
+			targetStats: p.Stats(),
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+type rlimit struct {
+	Soft uint64 `json:"soft"`
+	Hard uint64 `json:"hard"`
+}
+
+type adminRuntime struct {
+	GoVersion    string            `json:"go_version"`
+	GOMAXPROCS   int               `json:"gomaxprocs"`
+	NumCPU       int               `json:"num_cpu"`
+	NumGoroutine int               `json:"num_goroutine"`
+	Memory       adminMemory       `json:"memory"`
+	Rlimits      map[string]rlimit `json:"rlimits,omitempty"`
+}
+
+type adminMemory struct {
+	Alloc        uint64 `json:"alloc_bytes"`
+	TotalAlloc   uint64 `json:"total_alloc_bytes"`
+	Sys          uint64 `json:"sys_bytes"`
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	StackInuse   uint64 `json:"stack_inuse_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"pause_total_ns"`
+}
+
+// adminRuntimeHandler reports the scheduler, memory and resource limits of
+// the running process.
+func adminRuntimeHandler(w http.ResponseWriter, r *http.Request) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	out := adminRuntime{
+		GoVersion:    runtime.Version(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumCPU:       runtime.NumCPU(),
+		NumGoroutine: runtime.NumGoroutine(),
+		Memory: adminMemory{
+			Alloc:        m.Alloc,
+			TotalAlloc:   m.TotalAlloc,
+			Sys:          m.Sys,
+			HeapAlloc:    m.HeapAlloc,
+			HeapInuse:    m.HeapInuse,
+			HeapObjects:  m.HeapObjects,
+			StackInuse:   m.StackInuse,
+			NumGC:        m.NumGC,
+			PauseTotalNs: m.PauseTotalNs,
+		},
+		Rlimits: rlimits(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	defer func(v string) { adminToken = v }(adminToken)
+	adminToken = "s3cret"
+	defer auditLog.SetOutput(auditLog.Writer())
+	auditLog.SetOutput(ioutil.Discard)
+
+	h := adminAuth(func(w http.ResponseWriter, r *http.Request) {})
+	for _, tt := range []struct {
+		header string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"s3cret", http.StatusUnauthorized},
+		{"Basic s3cret", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	} {
+		req := httptest.NewRequest("GET", "/admin/targets", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("Authorization %q: status %d, want %d", tt.header, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestAdminTargetsHandler(t *testing.T) {
+	p := newTestClient("http://10.0.0.1:8000/ping", statePending)
+	withTargets(t).Add(p)
+	p.recordResult(nil)
+	p.recordResult(nil)
+	p.recordResult(errors.New("boom"))
+
+	rec := httptest.NewRecorder()
+	adminTargetsHandler(rec, httptest.NewRequest("GET", "/admin/targets", nil))
+	var got []adminTarget
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body, err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d targets, want 1", len(got))
+	}
+	want := adminTarget{
+		Endpoint:    "http://10.0.0.1:8000/ping",
+		State:       p.State().String(),
+		SuccessRate: 2.0 / 3,
+		Samples:     3,
+		targetStats: targetStats{Attempts: 3, Successes: 2, Failures: 1},
+	}
+	if got[0] != want {
+		t.Errorf("got %+v, want %+v", got[0], want)
+	}
+}
+
+func TestAdminRuntimeHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	adminRuntimeHandler(rec, httptest.NewRequest("GET", "/admin/runtime", nil))
+	var got adminRuntime
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", rec.Body, err)
+	}
+	if got.GoVersion == "" || got.GOMAXPROCS < 1 || got.NumCPU < 1 || got.NumGoroutine < 1 {
+		t.Errorf("runtime fields not populated: %+v", got)
+	}
+	if got.Memory.Sys == 0 || got.Memory.HeapAlloc == 0 || got.Memory.TotalAlloc < got.Memory.Alloc {
+		t.Errorf("memory fields not populated: %+v", got.Memory)
+	}
+	if rl := rlimits(); rl != nil {
+		if got.Rlimits["nofile"].Soft == 0 {
+			t.Errorf("nofile rlimit not reported: %+v", got.Rlimits)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+// auditLog records every admin action. It writes to AUDIT_LOG_FILE when set
+// and to stderr otherwise, so it can be routed separately from app logs.
+var auditLog = log.New(os.Stderr, "audit: ", log.LstdFlags|log.LUTC)
+
+func init() {
+	path := os.Getenv("AUDIT_LOG_FILE")
+	if path == "" {
+		return
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Fatalf("could not open AUDIT_LOG_FILE %s: %v\n", path, err)
+	}
+	auditLog.SetOutput(f)
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// audit logs who called an admin endpoint and its outcome.
+func audit(r *http.Request, user string, status int) {
+	source := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		source = host
+	}
+	auditLog.Printf("source=%s user=%s method=%s path=%s status=%d\n", source, user, r.Method, r.URL.Path, status)
+}
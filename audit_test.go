@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAuditEntries(t *testing.T) {
+	defer func(v string) { adminToken = v }(adminToken)
+	adminToken = "s3cret"
+	defer auditLog.SetOutput(auditLog.Writer())
+	var buf syncBuffer
+	auditLog.SetOutput(&buf)
+
+	h := adminAuth(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "busy", http.StatusConflict)
+	})
+	req := httptest.NewRequest("POST", "/admin/promote", nil)
+	req.RemoteAddr = "10.1.2.3:4567"
+	h(httptest.NewRecorder(), req)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	h(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	want := []string{
+		"source=10.1.2.3 user=anonymous method=POST path=/admin/promote status=401",
+		"source=10.1.2.3 user=admin-token method=POST path=/admin/promote status=409",
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d audit entries, want %d:\n%s", len(lines), len(want), buf.String())
+	}
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "audit: ") || !strings.HasSuffix(line, want[i]) {
+			t.Errorf("entry %d = %q, want %q", i, line, want[i])
+		}
+	}
+}
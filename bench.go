@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// benchResult summarises a runBench run.
+type benchResult struct {
+	requests int
+	errors   int
+	elapsed  time.Duration
+	p50      time.Duration
+	p90      time.Duration
+	p99      time.Duration
+	max      time.Duration
+}
+
+func (r benchResult) rps() float64 {
+	if r.elapsed <= 0 {
+		return 0
+	}
+	return float64(r.requests) / r.elapsed.Seconds()
+}
+
+func (r benchResult) String() string {
+	return fmt.Sprintf("%d requests (%d errors) in %v: %.1f req/s, p50=%v p90=%v p99=%v max=%v",
+		r.requests, r.errors, r.elapsed.Round(time.Millisecond), r.rps(), r.p50, r.p90, r.p99, r.max)
+}
+
+// runBench requests url from concurrency workers back to back until duration
+// has passed or ctx is cancelled. It is meant for sizing an instance against
+// its own /ping endpoint, not for use while serving real traffic.
+func runBench(ctx context.Context, url string, concurrency int, duration time.Duration) benchResult {
+	ctx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
+
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: concurrency}}
+	defer client.CloseIdleConnections()
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		failed    int
+		wg        sync.WaitGroup
+	)
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				began := time.Now()
+				err := benchRequest(ctx, client, url)
+				took := time.Since(began)
+				if ctx.Err() != nil {
+					// The request was cut short by the end of the run.
+					return
+				}
+				mu.Lock()
+				if err != nil {
+					failed++
+				} else {
+					latencies = append(latencies, took)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := benchResult{
+		requests: len(latencies) + failed,
+		errors:   failed,
+		elapsed:  time.Since(start),
+	}
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		result.p50 = percentile(latencies, 0.5)
+		result.p90 = percentile(latencies, 0.9)
+		result.p99 = percentile(latencies, 0.99)
+		result.max = latencies[len(latencies)-1]
+	}
+	return result
+}
+
+func benchRequest(ctx context.Context, client *http.Client, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected status OK, got %v", res.Status)
+	}
+	return nil
+}
+
+// percentile returns the nearest-rank q-quantile of the sorted samples.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	i := int(q*float64(len(sorted))+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunBench(t *testing.T) {
+	var served int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Every fourth request fails.
+		if atomic.AddInt32(&served, 1)%4 == 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		time.Sleep(time.Millisecond)
+		pingHandler(w, r)
+	}))
+	defer srv.Close()
+
+	r := runBench(context.Background(), srv.URL+"/ping", 4, 200*time.Millisecond)
+	if r.requests == 0 {
+		t.Fatal("no requests completed")
+	}
+	if r.errors == 0 || r.errors >= r.requests {
+		t.Errorf("%d errors in %d requests, want some but not all", r.errors, r.requests)
+	}
+	if r.elapsed < 200*time.Millisecond || r.elapsed > 2*time.Second {
+		t.Errorf("elapsed %v for a 200ms run", r.elapsed)
+	}
+	if !(time.Millisecond <= r.p50 && r.p50 <= r.p90 && r.p90 <= r.p99 && r.p99 <= r.max) {
+		t.Errorf("percentiles out of order: p50=%v p90=%v p99=%v max=%v", r.p50, r.p90, r.p99, r.max)
+	}
+	if r.rps() <= 0 {
+		t.Errorf("rps = %v", r.rps())
+	}
+	if s := r.String(); !strings.Contains(s, " requests (") || !strings.Contains(s, "req/s, p50=") {
+		t.Errorf("summary %q", s)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i))
+	}
+	for q, want := range map[float64]time.Duration{0: 1, 0.5: 50, 0.9: 90, 0.99: 99, 1: 100} {
+		if got := percentile(sorted, q); got != want {
+			t.Errorf("percentile(1..100, %v) = %v, want %v", q, got, want)
+		}
+	}
+	if got := percentile([]time.Duration{7}, 0.99); got != 7 {
+		t.Errorf("percentile of one sample = %v, want 7", got)
+	}
+}
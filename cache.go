@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var cacheHits = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payments_ping_cache_hit",
+		Help: "Ping responses by cache result as reported by response headers.",
+	},
+	[]string{"endpoint", "result"},
+)
+
+// cacheHeaders lists the response headers inspected for a cache result, in
+// order of precedence. Nil disables cache result recording.
+var cacheHeaders []string
+
+func init() {
+	registerer.MustRegister(cacheHits)
+	if !envBool("PING_RECORD_CACHE", false) {
+		return
+	}
+	cacheHeaders = []string{"X-Cache", "CF-Cache-Status", "Age"}
+	if v := os.Getenv("PING_CACHE_HEADERS"); v != "" {
+		cacheHeaders = nil
+		for _, h := range strings.Split(v, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				cacheHeaders = append(cacheHeaders, h)
+			}
+		}
+	}
+}
+
+// cacheResult returns "hit" or "miss" based on the first of names present in
+// h, or "none" when none of them are. An Age header counts as a hit when it
+// is positive; any other header counts as a hit when its value contains
+// "hit".
+func cacheResult(h http.Header, names []string) string {
+	for _, name := range names {
+		v := h.Get(name)
+		if v == "" {
+			continue
+		}
+		if strings.EqualFold(name, "Age") {
+			if age, err := strconv.Atoi(strings.TrimSpace(v)); err == nil && age > 0 {
+				return "hit"
+			}
+			return "miss"
+		}
+		if strings.Contains(strings.ToLower(v), "hit") {
+			return "hit"
+		}
+		return "miss"
+	}
+	return "none"
+}
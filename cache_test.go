@@ -0,0 +1,34 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCacheResult(t *testing.T) {
+	names := []string{"X-Cache", "CF-Cache-Status", "Age"}
+	for _, tc := range []struct {
+		name   string
+		header map[string]string
+		want   string
+	}{
+		{"no cache headers", nil, "none"},
+		{"X-Cache hit", map[string]string{"X-Cache": "Hit from cloudfront"}, "hit"},
+		{"X-Cache miss", map[string]string{"X-Cache": "Miss from cloudfront"}, "miss"},
+		{"CF-Cache-Status hit", map[string]string{"CF-Cache-Status": "HIT"}, "hit"},
+		{"CF-Cache-Status dynamic", map[string]string{"CF-Cache-Status": "DYNAMIC"}, "miss"},
+		{"positive Age", map[string]string{"Age": "120"}, "hit"},
+		{"zero Age", map[string]string{"Age": "0"}, "miss"},
+		{"invalid Age", map[string]string{"Age": "soon"}, "miss"},
+		{"precedence", map[string]string{"X-Cache": "MISS", "CF-Cache-Status": "HIT"}, "miss"},
+		{"unlisted header", map[string]string{"X-Cache-Status": "HIT"}, "none"},
+	} {
+		h := http.Header{}
+		for k, v := range tc.header {
+			h.Set(k, v)
+		}
+		if got := cacheResult(h, names); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
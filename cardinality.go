@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cardinalityMonitor warns when metrics gain new series faster than
+// maxPerMinute, which usually means an unbounded label such as a client IP
+// or a misconfigured target list.
+type cardinalityMonitor struct {
+	gatherer     prometheus.Gatherer
+	maxPerMinute float64
+	last         map[string]int
+	lastAt       time.Time
+}
+
+func newCardinalityMonitor(g prometheus.Gatherer, maxPerMinute float64) *cardinalityMonitor {
+	return &cardinalityMonitor{gatherer: g, maxPerMinute: maxPerMinute}
+}
+
+// check gathers the current series count of every metric family and returns
+// a warning for each family that grew faster than allowed since the
+// previous check. The first check only records a baseline.
+func (m *cardinalityMonitor) check(now time.Time) ([]string, error) {
+	families, err := m.gatherer.Gather()
+	if err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(families))
+	for _, mf := range families {
+		counts[mf.GetName()] = len(mf.GetMetric())
+	}
+	var warnings []string
+	if m.last != nil {
+		minutes := now.Sub(m.lastAt).Minutes()
+		for name, n := range counts {
+			added := n - m.last[name]
+			if added <= 0 || minutes <= 0 {
+				continue
+			}
+			if rate := float64(added) / minutes; rate > m.maxPerMinute {
+				warnings = append(warnings, fmt.Sprintf("%s grew by %d series to %d (%.1f/min, limit %.1f/min)",
+					name, added, n, rate, m.maxPerMinute))
+			}
+		}
+		sort.Strings(warnings)
+	}
+	m.last, m.lastAt = counts, now
+	return warnings, nil
+}
+
+// run checks the cardinality every interval until ctx is done. The baseline
+// is only taken after the first interval, so that the series every target
+// creates at startup are not mistaken for runaway growth.
+func (m *cardinalityMonitor) run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			warnings, err := m.check(now)
+			if err != nil {
+				log.Printf("could not gather metrics for cardinality check: %v\n", err)
+				continue
+			}
+			for _, w := range warnings {
+				log.Printf("WARNING: metric cardinality growing rapidly: %s\n", w)
+			}
+		}
+	}
+}
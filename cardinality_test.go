@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCardinalityMonitor(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	clients := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_clients_total", Help: "Test clients."}, []string{"ip"})
+	steady := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_steady_total", Help: "Test steady."}, []string{"endpoint"})
+	reg.MustRegister(clients, steady)
+	grow := func(v *prometheus.CounterVec, from, to int) {
+		for i := from; i < to; i++ {
+			v.WithLabelValues(fmt.Sprint(i)).Inc()
+		}
+	}
+	grow(steady, 0, 3)
+
+	m := newCardinalityMonitor(reg, 5)
+	now := time.Now()
+	check := func(after time.Duration) []string {
+		t.Helper()
+		now = now.Add(after)
+		warnings, err := m.check(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return warnings
+	}
+
+	if w := check(0); w != nil {
+		t.Errorf("baseline warned: %v", w)
+	}
+	grow(clients, 0, 20)
+	grow(steady, 3, 4)
+	want := []string{"test_clients_total grew by 20 series to 20 (10.0/min, limit 5.0/min)"}
+	if w := check(2 * time.Minute); !reflect.DeepEqual(w, want) {
+		t.Errorf("after rapid growth: got %q, want %q", w, want)
+	}
+	grow(clients, 20, 24)
+	if w := check(time.Minute); w != nil {
+		t.Errorf("growth within the limit warned: %v", w)
+	}
+	if w := check(time.Minute); w != nil {
+		t.Errorf("no growth warned: %v", w)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+)
+
+// classifyError maps a ping error to a short, stable reason suitable for
+// metric labels.
+func classifyError(err error) string {
+	var pe *pingError
+	if errors.As(err, &pe) {
+		return pe.reason
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return "refused"
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return "timeout"
+	}
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return "timeout"
+	}
+	return "error"
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// refusingAddr returns a loopback address that refuses connections.
+func refusingAddr(t *testing.T) string {
+	t.Helper()
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := list.Addr().String()
+	list.Close()
+	return addr
+}
+
+func TestRefusedConnection(t *testing.T) {
+	defer func(threshold int, refused bool) {
+		failureThreshold, refusedMeansDown = threshold, refused
+	}(failureThreshold, refusedMeansDown)
+	failureThreshold = 3
+	endpoint := "http://" + refusingAddr(t) + "/ping"
+
+	for _, refused := range []bool{false, true} {
+		refusedMeansDown = refused
+		p := newPingClient(endpoint, targetSpec{host: "127.0.0.1", timeout: time.Second})
+		_, err := p.ping()
+		if reason := classifyError(err); reason != "refused" {
+			t.Fatalf("got %v (%s), want refused", err, reason)
+		}
+		p.recordResult(err)
+		want := statePending
+		if refused {
+			want = stateDown
+		}
+		if got := p.State(); got != want {
+			t.Errorf("REFUSED_MEANS_DOWN=%v: state %v after one refused ping, want %v", refused, got, want)
+		}
+		p.mu.Lock()
+		p.setState(statePending)
+		p.mu.Unlock()
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connAger closes server connections once they are older than maxAge so that
+// long-lived keep-alive clients periodically reconnect. Active connections
+// are left to finish their current request and are closed once idle.
+type connAger struct {
+	maxAge time.Duration
+
+	mu    sync.Mutex
+	conns map[net.Conn]*agedConn
+}
+
+type agedConn struct {
+	timer   *time.Timer
+	idle    bool
+	expired bool
+}
+
+func newConnAger(maxAge time.Duration) *connAger {
+	return &connAger{
+		maxAge: maxAge,
+		conns:  make(map[net.Conn]*agedConn),
+	}
+}
+
+// ConnState is meant to be installed as http.Server.ConnState.
+func (a *connAger) ConnState(conn net.Conn, state http.ConnState) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		// A new connection has no request in flight yet, so it can be
+		// closed at expiry just like an idle one.
+		c := &agedConn{idle: true}
+		c.timer = time.AfterFunc(a.maxAge, func() { a.expire(conn) })
+		a.conns[conn] = c
+	case http.StateActive:
+		if c, ok := a.conns[conn]; ok {
+			c.idle = false
+		}
+	case http.StateIdle:
+		if c, ok := a.conns[conn]; ok {
+			c.idle = true
+			if c.expired {
+				conn.Close()
+			}
+		}
+	case http.StateHijacked, http.StateClosed:
+		if c, ok := a.conns[conn]; ok {
+			c.timer.Stop()
+			delete(a.conns, conn)
+		}
+	}
+}
+
+func (a *connAger) expire(conn net.Conn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.conns[conn]
+	if !ok {
+		return
+	}
+	c.expired = true
+	if c.idle {
+		conn.Close()
+	}
+}
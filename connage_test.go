@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const testMaxAge = 100 * time.Millisecond
+
+func newAgedServer(t *testing.T) *httptest.Server {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Config.ConnState = newConnAger(testMaxAge).ConnState
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// expectClosed fails unless the server closes conn within a few max ages.
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(10 * testMaxAge))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read data instead of the connection being closed")
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		t.Fatal("connection still open after max age")
+	}
+}
+
+func TestConnAgerClosesIdleConnection(t *testing.T) {
+	srv := newAgedServer(t)
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	expectClosed(t, conn)
+}
+
+func TestConnAgerClosesNewConnection(t *testing.T) {
+	srv := newAgedServer(t)
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// The connection never sends a request and so never becomes idle.
+	expectClosed(t, conn)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Thresholds of /consulz, as the fraction of targets that are down. The
+// check is a warning above consulWarning and critical above consulCritical.
+var (
+	consulWarning  float64
+	consulCritical float64
+)
+
+func init() {
+	consulWarning = envFloat("CONSUL_WARNING_DOWN_RATIO", 0)
+	consulCritical = envFloat("CONSUL_CRITICAL_DOWN_RATIO", 0.5)
+	if consulWarning < 0 || consulCritical > 1 || consulWarning > consulCritical {
+		log.Fatalf("invalid Consul thresholds: need 0 <= CONSUL_WARNING_DOWN_RATIO (%v) <= CONSUL_CRITICAL_DOWN_RATIO (%v) <= 1\n",
+			consulWarning, consulCritical)
+	}
+}
+
+// consulHandler reports the aggregate target health as a Consul HTTP check:
+// 200 is passing, 429 a warning and 503 critical. The body becomes the
+// check output.
+func consulHandler(w http.ResponseWriter, r *http.Request) {
+	clients := targets.Snapshot()
+	down := 0
+	for _, p := range clients {
+		if p.State() == stateDown {
+			down++
+		}
+	}
+	var ratio float64
+	if len(clients) > 0 {
+		ratio = float64(down) / float64(len(clients))
+	}
+	status, state := http.StatusOK, "passing"
+	switch {
+	case ratio > consulCritical:
+		status, state = http.StatusServiceUnavailable, "critical"
+	case ratio > consulWarning:
+		status, state = http.StatusTooManyRequests, "warning"
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s: %d of %d targets down\n", state, down, len(clients))
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestConsulHandler(t *testing.T) {
+	defer func(w, c float64) { consulWarning, consulCritical = w, c }(consulWarning, consulCritical)
+	consulWarning, consulCritical = 0.2, 0.5
+
+	for _, tt := range []struct {
+		down   int
+		status int
+		body   string
+	}{
+		{0, http.StatusOK, "passing: 0 of 4 targets down\n"},
+		{1, http.StatusTooManyRequests, "warning: 1 of 4 targets down\n"},
+		{2, http.StatusTooManyRequests, "warning: 2 of 4 targets down\n"},
+		{3, http.StatusServiceUnavailable, "critical: 3 of 4 targets down\n"},
+	} {
+		reg := withTargets(t)
+		for i := 0; i < 4; i++ {
+			state := stateUp
+			if i < tt.down {
+				state = stateDown
+			}
+			reg.Add(newTestClient(fmt.Sprintf("http://10.0.0.%d:8000/ping", i+1), state))
+		}
+		rec := httptest.NewRecorder()
+		consulHandler(rec, httptest.NewRequest("GET", "/consulz", nil))
+		if rec.Code != tt.status || rec.Body.String() != tt.body {
+			t.Errorf("%d of 4 down: %d %q, want %d %q", tt.down, rec.Code, rec.Body, tt.status, tt.body)
+		}
+	}
+
+	withTargets(t)
+	rec := httptest.NewRecorder()
+	consulHandler(rec, httptest.NewRequest("GET", "/consulz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("no targets: status %d, want 200", rec.Code)
+	}
+}
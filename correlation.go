@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+var (
+	// correlationHeader is echoed back by the /ping handler. When
+	// verifyCorrelation is set, the ping client sends it with a unique value
+	// and requires the target to echo it.
+	correlationHeader = "X-Correlation-ID"
+	verifyCorrelation bool
+)
+
+func init() {
+	if v := os.Getenv("PING_CORRELATION_HEADER"); v != "" {
+		correlationHeader = v
+	}
+	verifyCorrelation = envBool("PING_VERIFY_CORRELATION", false)
+}
+
+// newCorrelationID returns a random 128-bit identifier in hex.
+func newCorrelationID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// checkCorrelation verifies that the response echoes the correlation ID that
+// was sent with the request.
+func checkCorrelation(h http.Header, name, id string) error {
+	if got := h.Get(name); got != id {
+		return &pingError{reason: "correlation_mismatch", msg: fmt.Sprintf("expected %s %q, got %q", name, id, got)}
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCorrelationEcho(t *testing.T) {
+	defer func(v bool) { verifyCorrelation = v }(verifyCorrelation)
+	verifyCorrelation = true
+
+	echo := httptest.NewServer(http.HandlerFunc(pingHandler))
+	defer echo.Close()
+	noEcho := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer noEcho.Close()
+
+	if _, err := newTestPingClient(echo).ping(); err != nil {
+		t.Errorf("echoing server: unexpected error: %v", err)
+	}
+	_, err := newTestPingClient(noEcho).ping()
+	if reason := classifyError(err); reason != "correlation_mismatch" {
+		t.Errorf("non-echoing server: got %v (%s), want correlation_mismatch", err, reason)
+	}
+}
+
+func TestNewCorrelationIDIsUnique(t *testing.T) {
+	a, err := newCorrelationID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := newCorrelationID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(a) != 32 || a == b {
+		t.Errorf("got IDs %q and %q, want two distinct 128-bit hex IDs", a, b)
+	}
+}
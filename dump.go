@@ -0,0 +1,34 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// dumpMetrics writes everything gathered from g to path in the Prometheus
+// text format. The file is replaced atomically.
+func dumpMetrics(g prometheus.Gatherer, path string) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(tmp, mf); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
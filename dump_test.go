@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestDumpMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	pings := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "test_pings_total", Help: "Test pings."}, []string{"endpoint"})
+	reg.MustRegister(pings)
+	pings.WithLabelValues("a").Add(3)
+	pings.WithLabelValues("b").Inc()
+
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+	if err := dumpMetrics(reg, path); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# HELP test_pings_total Test pings.
+# TYPE test_pings_total counter
+test_pings_total{endpoint="a"} 3
+test_pings_total{endpoint="b"} 1
+`
+	if string(got) != want {
+		t.Errorf("dumped:\n%s\nwant:\n%s", got, want)
+	}
+	if matches, _ := filepath.Glob(path + ".tmp*"); len(matches) != 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
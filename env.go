@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// envDuration returns the duration stored in the named environment variable,
+// or def when it is unset. An unparseable value is fatal.
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v\n", name, v, err)
+	}
+	return d
+}
+
+// envBool returns the boolean stored in the named environment variable,
+// or def when it is unset. An unparseable value is fatal.
+func envBool(name string, def bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v\n", name, v, err)
+	}
+	return b
+}
+
+// envInt returns the integer stored in the named environment variable,
+// or def when it is unset. An unparseable value is fatal.
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v\n", name, v, err)
+	}
+	return i
+}
+
+// envFloat returns the float stored in the named environment variable,
+// or def when it is unset. An unparseable value is fatal.
+func envFloat(name string, def float64) float64 {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Fatalf("invalid %s %q: %v\n", name, v, err)
+	}
+	return f
+}
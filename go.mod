@@ -3,6 +3,9 @@ module spike-echo
 go 1.15
 
 require (
+	github.com/nats-io/nats.go v1.11.0
 	github.com/pires/go-proxyproto v0.1.3
 	github.com/prometheus/client_golang v1.7.1
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.10.0
 )
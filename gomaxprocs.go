@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log"
+	"math"
+	"os"
+	"runtime"
+)
+
+// tuneGOMAXPROCS sets GOMAXPROCS from the container CPU quota, unless the
+// GOMAXPROCS environment variable already sets it explicitly.
+func tuneGOMAXPROCS() {
+	if v := os.Getenv("GOMAXPROCS"); v != "" {
+		log.Printf("GOMAXPROCS set explicitly to %s, not tuning\n", v)
+		return
+	}
+	quota, ok, err := cpuQuota()
+	if err != nil {
+		log.Printf("could not read CPU quota: %v\n", err)
+		return
+	}
+	if !ok {
+		log.Printf("No CPU quota found, keeping GOMAXPROCS=%d\n", runtime.GOMAXPROCS(0))
+		return
+	}
+	procs := procsForQuota(quota)
+	prev := runtime.GOMAXPROCS(procs)
+	log.Printf("Set GOMAXPROCS to %d from CPU quota %.2f (was %d)\n", procs, quota, prev)
+}
+
+// procsForQuota converts a CPU quota, in CPUs, to a GOMAXPROCS value. Partial
+// CPUs are rounded down, but at least one is always used.
+func procsForQuota(quota float64) int {
+	procs := int(math.Floor(quota))
+	if procs < 1 {
+		return 1
+	}
+	return procs
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cgroupRoot is where the cgroup hierarchy of the container is mounted.
+const cgroupRoot = "/sys/fs/cgroup"
+
+func cpuQuota() (float64, bool, error) {
+	return cgroupCPUQuota(cgroupRoot)
+}
+
+// cgroupCPUQuota returns the CPU quota, in CPUs, of the cgroup mounted at
+// root. It understands both cgroup v2 (cpu.max) and cgroup v1
+// (cpu.cfs_quota_us and cpu.cfs_period_us). ok is false when no quota is set.
+func cgroupCPUQuota(root string) (quota float64, ok bool, err error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, "cpu.max"))
+	if err == nil {
+		// cgroup v2: "$MAX $PERIOD", where $MAX may be "max".
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 {
+			return 0, false, fmt.Errorf("unexpected cpu.max content %q", data)
+		}
+		if fields[0] == "max" {
+			return 0, false, nil
+		}
+		return ratio(fields[0], fields[1])
+	}
+	if !os.IsNotExist(err) {
+		return 0, false, err
+	}
+
+	for _, dir := range []string{"cpu", "cpu,cpuacct"} {
+		q, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_quota_us"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		p, err := ioutil.ReadFile(filepath.Join(root, dir, "cpu.cfs_period_us"))
+		if err != nil {
+			return 0, false, err
+		}
+		// A quota of -1 means unlimited.
+		if strings.TrimSpace(string(q)) == "-1" {
+			return 0, false, nil
+		}
+		return ratio(strings.TrimSpace(string(q)), strings.TrimSpace(string(p)))
+	}
+	return 0, false, nil
+}
+
+func ratio(quota, period string) (float64, bool, error) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid CPU quota %q: %v", quota, err)
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid CPU period %q: %v", period, err)
+	}
+	if q <= 0 || p <= 0 {
+		return 0, false, nil
+	}
+	return q / p, true, nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCgroupCPUQuota(t *testing.T) {
+	tests := []struct {
+		name   string
+		files  map[string]string
+		quota  float64
+		ok     bool
+		hasErr bool
+	}{
+		{"v2 quota", map[string]string{"cpu.max": "150000 100000\n"}, 1.5, true, false},
+		{"v2 unlimited", map[string]string{"cpu.max": "max 100000\n"}, 0, false, false},
+		{"v2 garbage", map[string]string{"cpu.max": "150000\n"}, 0, false, true},
+		{"v1 quota", map[string]string{
+			"cpu/cpu.cfs_quota_us":  "50000\n",
+			"cpu/cpu.cfs_period_us": "100000\n",
+		}, 0.5, true, false},
+		{"v1 combined controller", map[string]string{
+			"cpu,cpuacct/cpu.cfs_quota_us":  "400000\n",
+			"cpu,cpuacct/cpu.cfs_period_us": "100000\n",
+		}, 4, true, false},
+		{"v1 unlimited", map[string]string{
+			"cpu/cpu.cfs_quota_us":  "-1\n",
+			"cpu/cpu.cfs_period_us": "100000\n",
+		}, 0, false, false},
+		{"no cgroup", nil, 0, false, false},
+	}
+	for _, tt := range tests {
+		root := t.TempDir()
+		for name, content := range tt.files {
+			path := filepath.Join(root, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		quota, ok, err := cgroupCPUQuota(root)
+		if (err != nil) != tt.hasErr {
+			t.Errorf("%s: err = %v", tt.name, err)
+			continue
+		}
+		if quota != tt.quota || ok != tt.ok {
+			t.Errorf("%s: got %v, %v, want %v, %v", tt.name, quota, ok, tt.quota, tt.ok)
+		}
+	}
+}
+
+func TestProcsForQuota(t *testing.T) {
+	for quota, want := range map[float64]int{0.25: 1, 1: 1, 1.5: 1, 2: 2, 3.9: 3} {
+		if got := procsForQuota(quota); got != want {
+			t.Errorf("procsForQuota(%v) = %d, want %d", quota, got, want)
+		}
+	}
+}
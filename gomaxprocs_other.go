@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// cpuQuota is only implemented for Linux cgroups.
+func cpuQuota() (float64, bool, error) {
+	return 0, false, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// runHeartbeat logs a single liveness line every interval until ctx is done.
+func runHeartbeat(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Print(heartbeatLine())
+		}
+	}
+}
+
+func heartbeatLine() string {
+	clients := targets.Snapshot()
+	down := 0
+	for _, p := range clients {
+		if p.State() == stateDown {
+			down++
+		}
+	}
+	return fmt.Sprintf("heartbeat uptime=%v targets=%d targets_down=%d\n",
+		time.Since(startTime).Round(time.Second), len(clients), down)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncBuffer is a bytes.Buffer that is safe to use as log output while the
+// code under test logs from other goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog redirects the standard logger for the duration of a test.
+func captureLog(t *testing.T) *syncBuffer {
+	t.Helper()
+	buf := &syncBuffer{}
+	log.SetOutput(buf)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	return buf
+}
+
+func TestRunHeartbeat(t *testing.T) {
+	withTargets(t).Add(newTestClient("http://10.0.0.1:8000/ping", stateDown))
+	logs := captureLog(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runHeartbeat(ctx, 20*time.Millisecond)
+		close(done)
+	}()
+	time.Sleep(110 * time.Millisecond)
+	cancel()
+	<-done
+
+	lines := strings.Count(logs.String(), "heartbeat uptime=")
+	if lines < 2 || lines > 6 {
+		t.Errorf("got %d heartbeats in 110ms at a 20ms interval:\n%s", lines, logs)
+	}
+	if !strings.Contains(logs.String(), "targets=1 targets_down=1") {
+		t.Errorf("heartbeat does not report the targets:\n%s", logs)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if after := strings.Count(logs.String(), "heartbeat uptime="); after != lines {
+		t.Errorf("%d heartbeats logged after cancellation", after-lines)
+	}
+}
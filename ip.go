@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net"
+	"net/url"
+)
+
+// normalizeIP unwraps IPv4-mapped IPv6 addresses (::ffff:a.b.c.d) to their
+// 4-byte IPv4 form so that the same host always yields the same endpoint
+// and metric label. Other addresses are returned unchanged.
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+// remoteIP returns the normalised IP of a "host:port" remote address, or
+// the address itself when it does not hold an IP.
+func remoteIP(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return normalizeIP(ip).String()
+	}
+	return host
+}
+
+// endpointIP returns the IP address an endpoint URL points at, or nil when
+// its host is not an IP.
+func endpointIP(endpoint string) net.IP {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(u.Hostname())
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	for in, want := range map[string]string{
+		"::ffff:10.0.0.1": "10.0.0.1",
+		"10.0.0.1":        "10.0.0.1",
+		"2001:db8::1":     "2001:db8::1",
+	} {
+		got := normalizeIP(net.ParseIP(in))
+		if got.String() != want {
+			t.Errorf("normalizeIP(%s) = %s, want %s", in, got, want)
+		}
+		if got.To4() != nil && len(got) != net.IPv4len {
+			t.Errorf("normalizeIP(%s) has %d bytes, want the 4-byte form", in, len(got))
+		}
+	}
+}
+
+func TestRemoteIP(t *testing.T) {
+	for in, want := range map[string]string{
+		"[::ffff:10.0.0.1]:4567": "10.0.0.1",
+		"10.0.0.1:4567":          "10.0.0.1",
+		"[2001:db8::1]:4567":     "2001:db8::1",
+		"::ffff:10.0.0.1":        "10.0.0.1",
+		"@":                      "@",
+	} {
+		if got := remoteIP(in); got != want {
+			t.Errorf("remoteIP(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestEndpointIP(t *testing.T) {
+	for in, want := range map[string]string{
+		"http://10.0.0.1:8000/ping":  "10.0.0.1",
+		"https://[2001:db8::1]:443/": "2001:db8::1",
+		"http://echo:8000/ping":      "<nil>",
+		"unix:/run/echo.sock":        "<nil>",
+	} {
+		if got := endpointIP(in).String(); got != want {
+			t.Errorf("endpointIP(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
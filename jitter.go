@@ -0,0 +1,55 @@
+package main
+
+import "math"
+
+// jitterWindow keeps the variance of the most recent latency samples using
+// Welford's online algorithm, extended to drop the oldest sample once the
+// window is full. It is not safe for concurrent use.
+type jitterWindow struct {
+	samples []float64
+	next    int
+	count   int
+	mean    float64
+	m2      float64
+}
+
+func newJitterWindow(size int) *jitterWindow {
+	if size < 2 {
+		size = 2
+	}
+	return &jitterWindow{samples: make([]float64, size)}
+}
+
+// add records x, evicting the oldest sample once the window is full.
+func (w *jitterWindow) add(x float64) {
+	if w.count == len(w.samples) {
+		w.remove(w.samples[w.next])
+	}
+	w.samples[w.next] = x
+	w.next = (w.next + 1) % len(w.samples)
+
+	w.count++
+	delta := x - w.mean
+	w.mean += delta / float64(w.count)
+	w.m2 += delta * (x - w.mean)
+}
+
+func (w *jitterWindow) remove(x float64) {
+	w.count--
+	if w.count == 0 {
+		w.mean, w.m2 = 0, 0
+		return
+	}
+	delta := x - w.mean
+	w.mean -= delta / float64(w.count)
+	w.m2 -= delta * (x - w.mean)
+}
+
+// stddev returns the sample standard deviation of the window, or 0 with
+// fewer than two samples.
+func (w *jitterWindow) stddev() float64 {
+	if w.count < 2 || w.m2 <= 0 {
+		return 0
+	}
+	return math.Sqrt(w.m2 / float64(w.count-1))
+}
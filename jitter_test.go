@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// naiveStddev returns the sample standard deviation of xs.
+func naiveStddev(xs []float64) float64 {
+	if len(xs) < 2 {
+		return 0
+	}
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	mean := sum / float64(len(xs))
+	var sq float64
+	for _, x := range xs {
+		sq += (x - mean) * (x - mean)
+	}
+	return math.Sqrt(sq / float64(len(xs)-1))
+}
+
+func TestJitterWindowMatchesNaive(t *testing.T) {
+	const size = 5
+	w := newJitterWindow(size)
+	rng := rand.New(rand.NewSource(1))
+	var all []float64
+	for i := 0; i < 200; i++ {
+		// Latency that shifts level over time, so evictions change the mean.
+		x := float64(10*(i/50)) + rng.ExpFloat64()*5
+		all = append(all, x)
+		w.add(x)
+
+		recent := all
+		if len(recent) > size {
+			recent = recent[len(recent)-size:]
+		}
+		if got, want := w.stddev(), naiveStddev(recent); math.Abs(got-want) > 1e-6 {
+			t.Fatalf("after %d samples: stddev %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+func TestJitterWindowConstantLatency(t *testing.T) {
+	w := newJitterWindow(3)
+	if got := w.stddev(); got != 0 {
+		t.Errorf("empty window: stddev %v", got)
+	}
+	w.add(7)
+	if got := w.stddev(); got != 0 {
+		t.Errorf("single sample: stddev %v", got)
+	}
+	for i := 0; i < 10; i++ {
+		w.add(7)
+	}
+	if got := w.stddev(); got != 0 {
+		t.Errorf("constant latency: stddev %v, want 0", got)
+	}
+}
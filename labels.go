@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsRegistry holds every metric of the service and registerer
+// registers them, adding the constant labels from constLabels. Both are
+// initialised before any init function runs.
+var metricsRegistry, registerer = newMetricsRegistry(constLabels())
+
+// newMetricsRegistry returns a registry and a registerer adding labels to
+// every metric registered through it. Unlike the default registry, its Go
+// and process collectors carry the labels too.
+func newMetricsRegistry(labels prometheus.Labels) (*prometheus.Registry, prometheus.Registerer) {
+	reg := prometheus.NewRegistry()
+	r := prometheus.WrapRegistererWith(labels, reg)
+	r.MustRegister(prometheus.NewGoCollector())
+	r.MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
+	return reg, r
+}
+
+// metricsHandler serves metricsRegistry, counting its own scrapes like
+// promhttp.Handler does for the default registry.
+func metricsHandler() http.Handler {
+	return promhttp.InstrumentMetricHandler(registerer, promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{}))
+}
+
+// constLabels returns the labels added to every metric. With
+// METRICS_K8S_LABELS set, the pod, node and namespace are taken from the
+// Kubernetes downward API variables that are present. With
+// METRICS_INSTANCE_LABEL set, an instance label from INSTANCE_ID or the
+// hostname tells replicas apart behind a shared scrape endpoint; it is off
+// by default since Prometheus normally adds instance itself.
+func constLabels() prometheus.Labels {
+	labels := prometheus.Labels{}
+	if envBool("METRICS_K8S_LABELS", false) {
+		for label, env := range map[string]string{
+			"pod":       "POD_NAME",
+			"node":      "NODE_NAME",
+			"namespace": "POD_NAMESPACE",
+		} {
+			if v := os.Getenv(env); v != "" {
+				labels[label] = v
+			}
+		}
+	}
+	if envBool("METRICS_INSTANCE_LABEL", false) {
+		instance := os.Getenv("INSTANCE_ID")
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		if instance != "" {
+			labels["instance"] = instance
+		}
+	}
+	return labels
+}
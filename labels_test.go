@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// setenv sets the environment variable key to value, or unsets it when
+// value is empty, for the duration of a test.
+func setenv(t *testing.T, key, value string) {
+	t.Helper()
+	prev, ok := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, prev)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+}
+
+func TestConstLabels(t *testing.T) {
+	hostname, _ := os.Hostname()
+	for _, tt := range []struct {
+		name string
+		env  map[string]string
+		want prometheus.Labels
+	}{
+		{"disabled", map[string]string{"POD_NAME": "echo-0", "INSTANCE_ID": "i-1"}, prometheus.Labels{}},
+		{"k8s", map[string]string{
+			"METRICS_K8S_LABELS": "true",
+			"POD_NAME":           "echo-0",
+			"POD_NAMESPACE":      "payments",
+		}, prometheus.Labels{"pod": "echo-0", "namespace": "payments"}},
+		{"instance id", map[string]string{
+			"METRICS_INSTANCE_LABEL": "true",
+			"INSTANCE_ID":            "i-1",
+		}, prometheus.Labels{"instance": "i-1"}},
+		{"instance hostname", map[string]string{
+			"METRICS_INSTANCE_LABEL": "true",
+		}, prometheus.Labels{"instance": hostname}},
+	} {
+		for _, key := range []string{"METRICS_K8S_LABELS", "METRICS_INSTANCE_LABEL", "POD_NAME", "NODE_NAME", "POD_NAMESPACE", "INSTANCE_ID"} {
+			setenv(t, key, tt.env[key])
+		}
+		if got := constLabels(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsRegistryLabels(t *testing.T) {
+	reg, r := newMetricsRegistry(prometheus.Labels{"pod": "echo-0"})
+	pings := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_pings_total", Help: "Test pings."})
+	r.MustRegister(pings)
+	pings.Inc()
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]bool)
+	for _, mf := range families {
+		seen[mf.GetName()] = true
+		for _, m := range mf.GetMetric() {
+			var pod string
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "pod" {
+					pod = l.GetValue()
+				}
+			}
+			if pod != "echo-0" {
+				t.Errorf("%s%v lacks pod=\"echo-0\"", mf.GetName(), m.GetLabel())
+			}
+		}
+	}
+	for _, name := range []string{"test_pings_total", "go_goroutines", "process_start_time_seconds"} {
+		if !seen[name] {
+			t.Errorf("%s not gathered", name)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	h := metricsHandler()
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		for _, want := range []string{"go_goroutines", "process_start_time_seconds", "payments_targets_down", "promhttp_metric_handler_requests_total"} {
+			if !strings.Contains(rec.Body.String(), want) {
+				t.Errorf("/metrics lacks %s", want)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var loadPausedGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "payments_ping_load_paused",
+		Help: "1 while pinging is paused because of high local load.",
+	},
+)
+
+func init() {
+	registerer.MustRegister(loadPausedGauge)
+}
+
+// loadPaused is 1 while ping clients skip their pings because the local
+// machine is overloaded.
+var loadPaused int32
+
+func setLoadPaused(p bool) {
+	if p {
+		atomic.StoreInt32(&loadPaused, 1)
+		loadPausedGauge.Set(1)
+	} else {
+		atomic.StoreInt32(&loadPaused, 0)
+		loadPausedGauge.Set(0)
+	}
+}
+
+func isLoadPaused() bool {
+	return atomic.LoadInt32(&loadPaused) == 1
+}
+
+// watchLoad checks the 1-minute load average per CPU, as returned by load,
+// every interval and pauses pinging once it exceeds pause, resuming when it
+// falls to resume or below. It returns straight away when load reports no
+// load average.
+func watchLoad(ctx context.Context, load func() (float64, bool, error), pause, resume float64, interval time.Duration) {
+	if _, ok, _ := load(); !ok {
+		log.Printf("No load average available, not pausing pings under load\n")
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		avg, ok, err := load()
+		if err != nil {
+			log.Printf("could not read load average: %v\n", err)
+		} else if ok {
+			perCPU := avg / float64(runtime.NumCPU())
+			switch {
+			case !isLoadPaused() && perCPU > pause:
+				log.Printf("Load %.2f per CPU above %.2f, pausing pings\n", perCPU, pause)
+				setLoadPaused(true)
+			case isLoadPaused() && perCPU <= resume:
+				log.Printf("Load %.2f per CPU down to %.2f, resuming pings\n", perCPU, resume)
+				setLoadPaused(false)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// loadAvgPath is the kernel's load average file.
+const loadAvgPath = "/proc/loadavg"
+
+// loadAverage returns the 1-minute load average of the machine.
+func loadAverage() (float64, bool, error) {
+	data, err := ioutil.ReadFile(loadAvgPath)
+	if err != nil {
+		return 0, false, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, false, fmt.Errorf("empty %s", loadAvgPath)
+	}
+	load, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("parsing %s: %v", loadAvgPath, err)
+	}
+	return load, true, nil
+}
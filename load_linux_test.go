@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+func TestLoadAverage(t *testing.T) {
+	load, ok, err := loadAverage()
+	if err != nil || !ok {
+		t.Fatalf("loadAverage() = %v, %v, %v", load, ok, err)
+	}
+	if load < 0 {
+		t.Errorf("negative load average %v", load)
+	}
+}
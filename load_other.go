@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// loadAverage is only implemented for Linux.
+func loadAverage() (float64, bool, error) {
+	return 0, false, nil
+}
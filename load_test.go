@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWatchLoadPausesAndResumes(t *testing.T) {
+	defer setLoadPaused(false)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	cpus := float64(runtime.NumCPU())
+	// The load per CPU, scaled by 100 to fit an atomic integer.
+	perCPU := int64(50)
+	load := func() (float64, bool, error) {
+		return float64(atomic.LoadInt64(&perCPU)) / 100 * cpus, true, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchLoad(ctx, load, 2, 1, 5*time.Millisecond)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitPaused := func(want bool) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for isLoadPaused() != want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if isLoadPaused() != want {
+			t.Fatalf("load %v per CPU: paused = %v, want %v", float64(atomic.LoadInt64(&perCPU))/100, !want, want)
+		}
+		if got := testutil.ToFloat64(loadPausedGauge); (got == 1) != want {
+			t.Errorf("payments_ping_load_paused = %v while paused = %v", got, want)
+		}
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	waitPaused(false)
+	atomic.StoreInt64(&perCPU, 250)
+	waitPaused(true)
+	// Between the thresholds the pause holds.
+	atomic.StoreInt64(&perCPU, 150)
+	time.Sleep(20 * time.Millisecond)
+	waitPaused(true)
+	atomic.StoreInt64(&perCPU, 100)
+	waitPaused(false)
+}
+
+func TestWatchLoadWithoutSource(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	done := make(chan struct{})
+	go func() {
+		watchLoad(context.Background(), func() (float64, bool, error) { return 0, false, nil }, 2, 1, time.Millisecond)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watchLoad kept running without a load average")
+	}
+}
+
+func TestStartSkipsPingsWhileLoadPaused(t *testing.T) {
+	defer setLoadPaused(false)
+	var pings int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pings, 1)
+		pingHandler(w, r)
+	}))
+	defer target.Close()
+	p := newTestPingClient(target)
+	p.interval = 5 * time.Millisecond
+
+	setLoadPaused(true)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		waitPings(p)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&pings); n != 0 {
+		t.Errorf("%d pings while load paused", n)
+	}
+
+	setLoadPaused(false)
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&pings) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if atomic.LoadInt32(&pings) == 0 {
+		t.Error("no pings after the load pause lifted")
+	}
+}
@@ -2,16 +2,22 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
-	"github.com/pires/go-proxyproto"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"os"
 	"os/signal"
+	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -31,77 +37,576 @@ var (
 		},
 		[]string{"remote_ip"},
 	)
+	responseHeaderBytes = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "payments_ping_response_header_bytes",
+			Help:    "Total size of ping response headers.",
+			Buckets: prometheus.ExponentialBuckets(64, 2, 10),
+		},
+		[]string{"endpoint"},
+	)
+	responseHeaderCount = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "payments_ping_response_header_count",
+			Help:    "Number of ping response headers.",
+			Buckets: []float64{5, 10, 20, 30, 50, 75, 100},
+		},
+		[]string{"endpoint"},
+	)
+	recentSuccessRate = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payments_recent_success_rate",
+			Help: "Fraction of successful pings over the recent result window.",
+		},
+		[]string{"endpoint"},
+	)
+	pingJitter = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payments_ping_jitter_ms",
+			Help: "Standard deviation of ping latency over the recent sample window.",
+		},
+		[]string{"endpoint"},
+	)
+	targetsDown = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "payments_targets_down",
+			Help: "Number of ping endpoints currently considered down.",
+		},
+	)
+	pingErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payments_ping_error_count",
+			Help: "Failed pings by endpoint and reason.",
+		},
+		[]string{"endpoint", "reason"},
+	)
+	skippedPings = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payments_ping_skipped_count",
+			Help: "Scheduled pings skipped because earlier pings were still in flight.",
+		},
+		[]string{"endpoint"},
+	)
+	refusedClients = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "payments_ping_clients_refused_count",
+			Help: "Ping clients not started because MAX_GOROUTINES was reached.",
+		},
+	)
+	ttfb = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "payments_ping_ttfb_ms",
+			Help:    "Time from sending a ping to the first response byte.",
+			Buckets: []float64{0.1, 1, 5, 10, 25, 50, 100, 200, 500, 1000, 5000},
+		},
+		[]string{"endpoint"},
+	)
+	ttlb = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "payments_ping_ttlb_ms",
+			Help:    "Time from sending a ping to reading the last response byte.",
+			Buckets: []float64{0.1, 1, 5, 10, 25, 50, 100, 200, 500, 1000, 5000},
+		},
+		[]string{"endpoint"},
+	)
+	statusClasses = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payments_ping_status_class_total",
+			Help: "Pings by endpoint and response status class.",
+		},
+		[]string{"endpoint", "class"},
+	)
+	slaViolations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payments_sla_violation_total",
+			Help: "Pings slower than the configured SLA.",
+		},
+		[]string{"endpoint"},
+	)
+	connectDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "payments_ping_connect_ms",
+			Help:    "Time to establish a new connection for a ping.",
+			Buckets: []float64{0.1, 1, 5, 10, 25, 50, 100, 200, 500, 1000, 5000},
+		},
+		[]string{"endpoint"},
+	)
+	tlsHandshakeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "payments_ping_tls_handshake_ms",
+			Help:    "Time to complete the TLS handshake of a new connection for a ping.",
+			Buckets: []float64{0.1, 1, 5, 10, 25, 50, 100, 200, 500, 1000, 5000},
+		},
+		[]string{"endpoint"},
+	)
+	dnsDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "payments_ping_dns_duration_ms",
+			Help:    "Time to resolve the target hostname before a ping.",
+			Buckets: []float64{0.1, 1, 5, 10, 25, 50, 100, 200, 500, 1000, 5000},
+		},
+		[]string{"endpoint"},
+	)
+	dnsErrors = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payments_ping_dns_error_count",
+			Help: "Failed resolutions of the target hostname before a ping.",
+		},
+		[]string{"endpoint"},
+	)
+	dnsStale = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payments_ping_dns_stale",
+			Help: "1 if the target hostname no longer resolves to the pinged IP.",
+		},
+		[]string{"endpoint"},
+	)
+	clockAnomalies = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "payments_clock_anomaly_count",
+			Help: "Ping durations that were zero or negative and clamped to zero.",
+		},
+	)
+	lastLatency = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payments_last_latency_ms",
+			Help: "Latency of the most recent ping.",
+		},
+		[]string{"endpoint"},
+	)
 )
 
-var availabilityZone string
+var (
+	availabilityZone string
+	// expectJSONFields lists the top-level fields a ping response body must
+	// contain. Empty disables body validation.
+	expectJSONFields []string
+	// recordHeaders enables the response header size metrics.
+	recordHeaders bool
+	// windowSize is the number of recent results and latency samples kept
+	// per target.
+	windowSize int
+	// maxInFlight caps the number of concurrent pings per target.
+	maxInFlight int
+	// failureThreshold is the number of consecutive failures after which a
+	// target is considered down.
+	failureThreshold int
+	// refusedMeansDown marks a target down on the first refused connection,
+	// regardless of failureThreshold. With the default threshold of 1 every
+	// failure already does, so it only matters with a higher threshold.
+	refusedMeansDown bool
+	// recordTimings enables the time to first and last byte metrics.
+	recordTimings bool
+	// recordStatusClass enables the per status class ping counters.
+	recordStatusClass bool
+	// recordLastLatency enables the last ping latency gauge.
+	recordLastLatency bool
+	// recordMSS enables the TCP MSS gauge of ping connections, on Linux.
+	recordMSS bool
+	// forceNewConn disables keep-alive for pings so that every ping pays, and
+	// records, the full connection setup cost.
+	forceNewConn bool
+	// resolveEachCycle resolves the target hostname before every ping, to
+	// time the resolver and detect pinged IPs the hostname no longer has.
+	resolveEachCycle bool
+	// rotateEvery forces a new connection every rotateEvery pings. Zero keeps
+	// connections for as long as the transport does.
+	rotateEvery int
+	// pingScheme is the URL scheme, http or https, used to ping resolved IPs.
+	pingScheme string
+	// logResolvedTargets logs the endpoints resolved from REMOTE_ADDR, both
+	// at startup and when a host resolves late.
+	logResolvedTargets bool
+	// maxGoroutines stops new ping clients from being started once exceeded.
+	// Zero disables the guard.
+	maxGoroutines int
+)
 
 func init() {
-	prometheus.MustRegister(callSummary)
-	prometheus.MustRegister(pingRequests)
+	registerer.MustRegister(callSummary)
+	registerer.MustRegister(pingRequests)
+	registerer.MustRegister(responseHeaderBytes)
+	registerer.MustRegister(responseHeaderCount)
+	registerer.MustRegister(clockAnomalies)
+	registerer.MustRegister(targetsDown)
+	registerer.MustRegister(recentSuccessRate)
+	registerer.MustRegister(skippedPings)
+	registerer.MustRegister(pingErrors)
+	registerer.MustRegister(refusedClients)
+	registerer.MustRegister(pingJitter)
+	registerer.MustRegister(ttfb)
+	registerer.MustRegister(ttlb)
+	registerer.MustRegister(statusClasses)
+	registerer.MustRegister(slaViolations)
+	registerer.MustRegister(connectDuration)
+	registerer.MustRegister(tlsHandshakeDuration)
+	registerer.MustRegister(dnsDuration)
+	registerer.MustRegister(dnsErrors)
+	registerer.MustRegister(dnsStale)
+	registerer.MustRegister(lastLatency)
 	availabilityZone = os.Getenv("AVAILABILITY_ZONE")
+	if fields := os.Getenv("PING_EXPECT_JSON_FIELDS"); fields != "" {
+		for _, f := range strings.Split(fields, ",") {
+			if f = strings.TrimSpace(f); f != "" {
+				expectJSONFields = append(expectJSONFields, f)
+			}
+		}
+	}
+	recordHeaders = envBool("PING_RECORD_HEADERS", false)
+	windowSize = envInt("PING_WINDOW_SIZE", 60)
+	if maxInFlight = envInt("PING_MAX_IN_FLIGHT", 1); maxInFlight < 1 {
+		log.Fatalf("invalid PING_MAX_IN_FLIGHT %d: must be at least 1\n", maxInFlight)
+	}
+	if failureThreshold = envInt("PING_FAILURE_THRESHOLD", 1); failureThreshold < 1 {
+		log.Fatalf("invalid PING_FAILURE_THRESHOLD %d: must be at least 1\n", failureThreshold)
+	}
+	refusedMeansDown = envBool("REFUSED_MEANS_DOWN", false)
+	maxGoroutines = envInt("MAX_GOROUTINES", 0)
+	recordTimings = envBool("PING_RECORD_TIMINGS", false)
+	recordStatusClass = envBool("PING_RECORD_STATUS_CLASS", false)
+	recordLastLatency = envBool("PING_RECORD_LAST_LATENCY", false)
+	recordMSS = envBool("PING_RECORD_MSS", false)
+	forceNewConn = envBool("PING_FORCE_NEW_CONN", false)
+	resolveEachCycle = envBool("PING_RESOLVE_EACH_CYCLE", false)
+	logResolvedTargets = envBool("LOG_RESOLVED_TARGETS", true)
+	switch pingScheme = os.Getenv("PING_SCHEME"); pingScheme {
+	case "":
+		pingScheme = "http"
+	case "http", "https":
+	default:
+		log.Fatalf("invalid PING_SCHEME %q: must be http or https\n", pingScheme)
+	}
+	if envBool("PING_ROTATE_CONNECTIONS", false) {
+		if rotateEvery = envInt("PING_ROTATE_EVERY", 10); rotateEvery < 1 {
+			log.Fatalf("invalid PING_ROTATE_EVERY %d: must be at least 1\n", rotateEvery)
+		}
+	}
 }
 
 func main() {
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ping", pingHandler)
-	mux.HandleFunc("/healthz", healthHandler)
-	mux.Handle("/metrics", promhttp.Handler())
+	if envBool("AUTO_GOMAXPROCS", false) {
+		tuneGOMAXPROCS()
+	}
+
+	mux := newAppMux()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	if url := os.Getenv("NATS_URL"); url != "" {
+		buffer := envInt("NATS_BUFFER", 1024)
+		if buffer < 1 {
+			log.Fatalf("invalid NATS_BUFFER %d: must be at least 1\n", buffer)
+		}
+		var err error
+		if results, err = startNATSPublisher(ctx, url, buffer); err != nil {
+			log.Fatalf("could not connect to NATS at %s: %v\n", url, err)
+		}
+	}
+
+	statePath := os.Getenv("STATE_FILE")
+	if statePath != "" {
+		if err := loadState(statePath); err != nil {
+			log.Printf("could not load state from %s, starting fresh: %v\n", statePath, err)
+		}
+		go runStateSaver(ctx, statePath, envDuration("STATE_INTERVAL", 30*time.Second))
+	}
+
 	remoteAddrs := os.Getenv("REMOTE_ADDR")
-	if remoteAddrs != "" {
-		addrs := strings.Split(remoteAddrs, ",")
+	// Without PING_ENABLED, pinging is implied by a non-empty REMOTE_ADDR.
+	addrs, warning := pingAddrs(envBool("PING_ENABLED", remoteAddrs != ""), remoteAddrs)
+	if warning != "" {
+		log.Printf("%s\n", warning)
+	}
+	if len(addrs) > 0 {
+		def, err := defaultTargetSpec(envDuration("PING_INTERVAL", time.Second),
+			envDuration("PING_TIMEOUT", 10*time.Second), envDuration("PING_SLA", 0))
+		if err != nil {
+			log.Fatalf("%v\n", err)
+		}
+		var resolved []resolvedTarget
 		for _, addr := range addrs {
-			startPinging(ctx, addr)
+			spec, err := parseTargetSpec(addr, def)
+			if err != nil {
+				log.Fatalf("invalid REMOTE_ADDR entry: %v\n", err)
+			}
+			resolved = append(resolved, startPinging(ctx, spec))
+		}
+		if logResolvedTargets {
+			log.Print(formatResolvedTargets(resolved))
 		}
 	}
 
 	addr := fmt.Sprintf(":%s", os.Getenv("PORT"))
-	list, err := net.Listen("tcp", addr)
+	list, err := listen(0, addr)
 	if err != nil {
 		log.Fatalf("could not listen to %s: %v\n", addr, err)
 	}
-	proxyListener := &proxyproto.Listener{Listener: list}
-	defer proxyListener.Close()
-
-	go createPrometheusEndpoint(ctx)
+	// http.Server.Serve already retries temporary accept errors with a
+	// backoff, so only permanent errors end serving.
+	var appList net.Listener = list
+	switch policy := os.Getenv("PROXY_PROTOCOL"); policy {
+	case "", "optional", "required":
+		// The self-test and benchmark clients dial the app port directly
+		// and never send a PROXY header.
+		if policy == "required" && (envBool("SELF_TEST", false) || envBool("SELF_BENCH", false)) {
+			log.Fatalf("PROXY_PROTOCOL=required cannot be combined with SELF_TEST or SELF_BENCH\n")
+		}
+		var fallback bool
+		switch mode := os.Getenv("PROXY_MALFORMED"); mode {
+		case "", "reject":
+		case "fallback":
+			fallback = true
+		default:
+			log.Fatalf("invalid PROXY_MALFORMED %q: must be reject or fallback\n", mode)
+		}
+		appList = &proxyHeaderListener{
+			Listener: appList,
+			required: policy == "required",
+			fallback: fallback,
+			timeout:  envDuration("PROXY_HEADER_TIMEOUT", 5*time.Second),
+		}
+	case "off":
+	default:
+		log.Fatalf("invalid PROXY_PROTOCOL %q: must be optional, required or off\n", policy)
+	}
+	defer appList.Close()
 
-	srv := &http.Server{Handler: mux}
+	srv := newAppServer(mux, envDuration("SERVER_MAX_CONN_AGE", 0), envBool("SERVER_DISABLE_KEEPALIVE", false))
+	servers := map[string]*http.Server{"app": srv}
+	listeners := []net.Listener{list}
+	// The app mux already serves /metrics, /healthz and /readyz, so with
+	// SINGLE_PORT the dedicated metrics server is skipped.
+	if !envBool("SINGLE_PORT", false) {
+		metricsSrv := newPrometheusServer()
+		if metricsList, err := listen(1, metricsSrv.Addr); err != nil {
+			log.Printf("could not listen to %s: %v\n", metricsSrv.Addr, err)
+		} else {
+			servers["metrics"] = metricsSrv
+			listeners = append(listeners, metricsList)
+			go metricsSrv.Serve(metricsList)
+		}
+	}
 
+	shutdownDone := make(chan struct{})
 	go func() {
 		<-ctx.Done()
-		timeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-		defer cancel()
-		srv.Shutdown(timeout)
+		timeout := envDuration("SHUTDOWN_TIMEOUT", 5*time.Second)
+		stuck := shutdownServers(servers, timeout)
+		persistFinalState(statePath, os.Getenv("METRICS_DUMP_FILE"))
+		if len(stuck) > 0 {
+			log.Printf("shutdown did not complete within %v, not drained: %v; forcing exit\n", timeout, stuck)
+			os.Exit(hardKillExitCode)
+		}
+		close(shutdownDone)
 	}()
 
+	if interval := envDuration("HEARTBEAT_INTERVAL", 0); interval > 0 {
+		go runHeartbeat(ctx, interval)
+	}
+
+	if pause := envFloat("PING_PAUSE_LOAD", 0); pause > 0 {
+		resume := envFloat("PING_RESUME_LOAD", pause)
+		if resume > pause {
+			log.Fatalf("invalid PING_RESUME_LOAD %v: must not exceed PING_PAUSE_LOAD %v\n", resume, pause)
+		}
+		go watchLoad(ctx, loadAverage, pause, resume, envDuration("PING_LOAD_CHECK_INTERVAL", 10*time.Second))
+	}
+
+	// CARDINALITY_WARN_PER_MINUTE is a soft signal well before any series
+	// limit is hit: it only logs.
+	if limit := envInt("CARDINALITY_WARN_PER_MINUTE", 0); limit > 0 {
+		m := newCardinalityMonitor(metricsRegistry, float64(limit))
+		go m.run(ctx, envDuration("CARDINALITY_CHECK_INTERVAL", time.Minute))
+	}
+
+	if envBool("SELF_TEST", false) {
+		go func() {
+			baseURL := fmt.Sprintf("http://127.0.0.1:%d", list.Addr().(*net.TCPAddr).Port)
+			timeout := envDuration("SELF_TEST_TIMEOUT", 5*time.Second)
+			if err := runSelfTest(ctx, baseURL, selfTestPaths, timeout); err != nil {
+				log.Fatalf("%v\n", err)
+			}
+			log.Printf("Self-test passed for %v\n", selfTestPaths)
+		}()
+	}
+
+	if envBool("SELF_BENCH", false) {
+		go func() {
+			url := fmt.Sprintf("http://127.0.0.1:%d/ping", list.Addr().(*net.TCPAddr).Port)
+			concurrency := envInt("SELF_BENCH_CONCURRENCY", 8)
+			if concurrency < 1 {
+				log.Fatalf("invalid SELF_BENCH_CONCURRENCY %d: must be at least 1\n", concurrency)
+			}
+			duration := envDuration("SELF_BENCH_DURATION", 10*time.Second)
+			log.Printf("Benchmarking %s with %d workers for %v\n", url, concurrency, duration)
+			log.Printf("Benchmark: %v\n", runBench(ctx, url, concurrency, duration))
+			stopScheduling()
+			cancel()
+		}()
+	}
+
+	if envBool("GRACEFUL_RESTART", false) {
+		go watchRestart(func() {
+			stopScheduling()
+			cancel()
+		}, envDuration("GRACEFUL_RESTART_TIMEOUT", 30*time.Second), listeners...)
+	}
+
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		<-sigs
 		fmt.Printf("Stopping")
+		stopScheduling()
 		cancel()
 	}()
 
-	log.Fatal(srv.Serve(proxyListener))
+	notifyReady()
+	if err := srv.Serve(appList); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-shutdownDone
+}
+
+// pingAddrs returns the REMOTE_ADDR entries to ping, given whether pinging
+// is enabled. The warning explains a combination that pings nothing.
+func pingAddrs(enabled bool, remoteAddrs string) ([]string, string) {
+	switch {
+	case enabled && remoteAddrs == "":
+		return nil, "WARNING: pinging enabled but no targets, REMOTE_ADDR is empty"
+	case !enabled && remoteAddrs != "":
+		return nil, fmt.Sprintf("Pinging disabled, ignoring REMOTE_ADDR %q", remoteAddrs)
+	case enabled:
+		return strings.Split(remoteAddrs, ","), ""
+	}
+	return nil, ""
 }
 
-func startPinging(ctx context.Context, remoteAddr string) {
-	fmt.Printf("Resolving %v\n", remoteAddr)
-	ips, err := net.LookupIP(remoteAddr)
+func startPinging(ctx context.Context, spec targetSpec) resolvedTarget {
+	if _, ok := unixSocketPath(spec.host); ok {
+		resolved := resolvedTarget{host: spec.host}
+		if startClient(ctx, spec.host, spec) {
+			resolved.endpoints = append(resolved.endpoints, spec.host)
+		}
+		return resolved
+	}
+
+	fmt.Printf("Resolving %v\n", spec.host)
+	ips, err := net.LookupIP(spec.host)
 	if err != nil {
-		log.Fatalf("could not look up ip addresses: %v\n", err)
+		log.Printf("could not look up ip addresses of %v: %v; retrying in background\n", spec.host, err)
+		go retryPinging(ctx, spec)
+		return resolvedTarget{host: spec.host, err: err}
 	}
+	return startResolved(ctx, spec, ips)
+}
 
+// retryPinging keeps resolving a host that failed to resolve at startup,
+// with a capped backoff, and starts its clients once it resolves.
+func retryPinging(ctx context.Context, spec targetSpec) {
+	delay := time.Second
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		ips, err := net.LookupIP(spec.host)
+		if err != nil {
+			if delay *= 2; delay > time.Minute {
+				delay = time.Minute
+			}
+			log.Printf("could not look up ip addresses of %v: %v; retrying in %v\n", spec.host, err, delay)
+			continue
+		}
+		resolved := startResolved(ctx, spec, ips)
+		if logResolvedTargets {
+			log.Print(formatResolvedTargets([]resolvedTarget{resolved}))
+		}
+		return
+	}
+}
+
+// startResolved starts a ping client for every IPv4 address of spec.host.
+func startResolved(ctx context.Context, spec targetSpec, ips []net.IP) resolvedTarget {
+	resolved := resolvedTarget{host: spec.host}
+	seen := make(map[string]bool)
 	for _, ip := range ips {
-		if ip.To4() == nil {
+		// A resolver may return the same address both plain and
+		// IPv4-mapped; both must map to a single endpoint.
+		ip = normalizeIP(ip)
+		if len(ip) != net.IPv4len || seen[ip.String()] {
 			continue
 		}
-		remoteEndpoint := fmt.Sprintf("http://%s:8000/ping", ip.To4())
-		log.Printf("Starting client for endpoint: %v\n", remoteEndpoint)
-		go newPingClient(remoteEndpoint).Start(ctx)
+		seen[ip.String()] = true
+		remoteEndpoint := fmt.Sprintf("%s://%s:8000/ping", pingScheme, ip)
+		resolved.ips = append(resolved.ips, ip)
+		if startClient(ctx, remoteEndpoint, spec) {
+			resolved.endpoints = append(resolved.endpoints, remoteEndpoint)
+		}
+	}
+	return resolved
+}
+
+// startClient starts and registers a ping client for endpoint. It refuses to
+// do so, returning false, once the process runs more than maxGoroutines or
+// when endpoint already has a client.
+func startClient(ctx context.Context, endpoint string, spec targetSpec) bool {
+	if maxGoroutines > 0 {
+		if n := runtime.NumGoroutine(); n >= maxGoroutines {
+			log.Printf("WARNING: not starting client for endpoint %v: %d goroutines running, MAX_GOROUTINES is %d\n", endpoint, n, maxGoroutines)
+			refusedClients.Inc()
+			return false
+		}
+	}
+	client := newPingClient(endpoint, spec)
+	if !targets.Add(client) {
+		log.Printf("WARNING: not starting client for endpoint %v: already pinged\n", endpoint)
+		return false
+	}
+	log.Printf("Starting client for endpoint: %v\n", endpoint)
+	go client.Start(ctx)
+	return true
+}
+
+// newAppServer returns the server of the app port. A positive maxAge closes
+// connections once they are older, and disableKeepAlive closes every
+// connection after one request.
+func newAppServer(handler http.Handler, maxAge time.Duration, disableKeepAlive bool) *http.Server {
+	srv := &http.Server{Handler: handler}
+	if maxAge > 0 {
+		srv.ConnState = newConnAger(maxAge).ConnState
+	}
+	if disableKeepAlive {
+		srv.SetKeepAlivesEnabled(false)
+	}
+	return srv
+}
+
+// newAppMux returns the handler of the app port. Besides /ping it serves
+// /metrics, /healthz and /readyz, allowing SINGLE_PORT deployments.
+func newAppMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ping", pingHandler)
+	mux.HandleFunc("/healthz", healthHandler)
+	mux.HandleFunc("/readyz", readyHandler)
+	mux.HandleFunc("/version", versionHandler)
+	mux.HandleFunc("/admin/targets", adminAuth(adminTargetsHandler))
+	mux.HandleFunc("/admin/runtime", adminAuth(adminRuntimeHandler))
+	// Promoting and demoting change what the instance does, so unlike the
+	// read-only admin endpoints they are never served without a token.
+	if adminToken != "" {
+		mux.HandleFunc("/admin/promote", adminAuth(standbyHandler(true)))
+		mux.HandleFunc("/admin/demote", adminAuth(standbyHandler(false)))
+	}
+	mux.Handle("/metrics", metricsHandler())
+	if envBool("CONSUL_CHECK", false) {
+		mux.HandleFunc("/consulz", consulHandler)
 	}
+	if envBool("STATUS_PAGE", false) {
+		mux.HandleFunc("/", statusHandler)
+	}
+	return mux
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -109,86 +614,466 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 type pingClient struct {
-	client   *http.Client
+	client *http.Client
+	// endpoint identifies the target in logs and metric labels.
 	endpoint string
+	// url is the address requested on every ping.
+	url string
+	// host is the configured hostname the endpoint was resolved from.
+	host     string
+	interval time.Duration
+	timeout  time.Duration
+	sla      time.Duration
+
+	// redirect, when set, also probes the target for an HTTPS redirect.
+	redirect *redirectProbe
+
+	// inFlight holds a token for every ping currently running.
+	inFlight chan struct{}
+
+	mu    sync.Mutex
+	state targetState
+	// failures counts consecutive failed pings.
+	failures int
+	window   *resultWindow
+	jitter   *jitterWindow
+	// proto is the HTTP protocol version of the latest response.
+	proto string
+	// pings counts the pings started by this client.
+	pings int
+	// stats are the cumulative results, including any restored from
+	// STATE_FILE.
+	stats targetStats
 }
 
-func newPingClient(remoteEndpoint string) *pingClient {
+func newPingClient(remoteEndpoint string, spec targetSpec) *pingClient {
+	transport := &http.Transport{
+		DisableKeepAlives: forceNewConn,
+		IdleConnTimeout:   time.Minute,
+		TLSClientConfig:   pingTLSConfig(spec.host),
+	}
+	url := remoteEndpoint
+	path, isUnix := unixSocketPath(remoteEndpoint)
+	if isUnix {
+		// The host is only a placeholder, every request is dialed to the socket.
+		url = "http://localhost/ping"
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		}
+	} else if recordMSS {
+		transport.DialContext = mssDialer(remoteEndpoint)
+	}
 	client := &http.Client{
-		Transport: &http.Transport{
-			DisableKeepAlives: false,
-			IdleConnTimeout:   time.Minute,
-		},
+		Transport: transport,
+	}
+	var redirect *redirectProbe
+	if checkHTTPSRedirect && !isUnix && pingScheme == "http" {
+		var err error
+		if redirect, err = newRedirectProbe(url, spec.host); err != nil {
+			log.Printf("not probing %s for HTTPS redirect: %v\n", url, err)
+		}
 	}
 	return &pingClient{
 		client:   client,
+		redirect: redirect,
 		endpoint: remoteEndpoint,
+		url:      url,
+		host:     spec.host,
+		interval: spec.interval,
+		timeout:  spec.timeout,
+		sla:      spec.sla,
+		inFlight: make(chan struct{}, maxInFlight),
+		window:   newResultWindow(windowSize),
+		jitter:   newJitterWindow(windowSize),
+		stats:    restoredStats(remoteEndpoint),
 	}
 }
 
+// unixSocketPath returns the socket path of a "unix:/path/to.sock" target.
+func unixSocketPath(target string) (string, bool) {
+	if !strings.HasPrefix(target, "unix:") {
+		return "", false
+	}
+	return strings.TrimPrefix(target, "unix:"), true
+}
+
+// Start pings the target every interval until ctx is done. A scheduled ping
+// is skipped while the instance is a standby or while the maximum number of
+// pings is already in flight.
 func (p *pingClient) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
 	for {
+		if schedulingStopped() {
+			return
+		}
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(time.Second):
-			start := time.Now()
-			err := p.ping()
-			duration := time.Since(start)
-			callSummary.WithLabelValues(availabilityZone, p.endpoint).Observe(float64(duration.Milliseconds()))
-			if err != nil {
-				fmt.Printf("Received err: %v, after: %v\n", err, duration)
+		case <-ticker.C:
+			if schedulingStopped() {
+				return
+			}
+			if !isActive() || isLoadPaused() {
 				continue
 			}
+			select {
+			case p.inFlight <- struct{}{}:
+				go func() {
+					defer func() { <-p.inFlight }()
+					p.probe()
+				}()
+			default:
+				skippedPings.WithLabelValues(p.endpoint).Inc()
+			}
 		}
 	}
 }
 
-func (p *pingClient) ping() error {
-	timeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+// statusClass buckets an HTTP status code into "1xx" to "5xx", or "error"
+// when no valid status was received.
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "error"
+	}
+	return fmt.Sprintf("%dxx", code/100)
+}
+
+// stopped is set once ping clients must not start any new pings, while
+// pings already in flight are allowed to finish.
+var stopped int32
+
+// stopScheduling stops all ping clients from starting new pings.
+func stopScheduling() {
+	atomic.StoreInt32(&stopped, 1)
+}
+
+func schedulingStopped() bool {
+	return atomic.LoadInt32(&stopped) == 1
+}
+
+// probe runs a single ping and records its outcome.
+func (p *pingClient) probe() {
+	if _, isUnix := unixSocketPath(p.host); resolveEachCycle && !isUnix {
+		p.resolve()
+	}
+	start := time.Now()
+	status, err := p.ping()
+	duration := clampDuration(time.Since(start))
+	if p.sla > 0 && duration > p.sla {
+		slaViolations.WithLabelValues(p.endpoint).Inc()
+	}
+	if recordStatusClass {
+		statusClasses.WithLabelValues(p.endpoint, statusClass(status)).Inc()
+	}
+	callSummary.WithLabelValues(availabilityZone, p.endpoint).Observe(float64(duration.Milliseconds()))
+	if latencySummary != nil {
+		latencySummary.WithLabelValues(availabilityZone, p.endpoint).Observe(float64(duration.Milliseconds()))
+	}
+	if recordLastLatency {
+		lastLatency.WithLabelValues(p.endpoint).Set(float64(duration) / float64(time.Millisecond))
+	}
+	p.recordLatency(duration)
+	p.recordResult(err)
+	if results != nil {
+		r := pingResult{
+			Timestamp:        start,
+			Endpoint:         p.endpoint,
+			AvailabilityZone: availabilityZone,
+			LatencyMs:        float64(duration) / float64(time.Millisecond),
+			Status:           status,
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		results.Publish(r)
+	}
+	if p.redirect != nil {
+		p.redirect.check(p.endpoint, p.timeout)
+	}
+	if len(probeMethods) > 0 {
+		p.checkMethods()
+	}
+	if err != nil {
+		fmt.Printf("Received err: %v (%s), after: %v\n", err, classifyError(err), duration)
+	}
+}
+
+// recordResult updates the target state from the outcome of a ping.
+func (p *pingClient) recordResult(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stats.Attempts++
+	if err == nil {
+		p.stats.Successes++
+		p.failures = 0
+		p.setState(stateUp)
+	} else {
+		reason := classifyError(err)
+		pingErrors.WithLabelValues(p.endpoint, reason).Inc()
+		p.stats.Failures++
+		p.failures++
+		if p.failures >= failureThreshold || (reason == "refused" && refusedMeansDown) {
+			p.setState(stateDown)
+		}
+	}
+	p.window.add(err == nil)
+	rate, _ := p.window.successRate()
+	recentSuccessRate.WithLabelValues(p.endpoint).Set(rate)
+}
+
+// setState transitions the target to state, keeping payments_targets_down in
+// sync. p.mu must be held.
+func (p *pingClient) setState(state targetState) {
+	if state == p.state {
+		return
+	}
+	if p.state == stateDown {
+		targetsDown.Dec()
+	}
+	if state == stateDown {
+		targetsDown.Inc()
+	}
+	p.state = state
+}
+
+// State returns the current state of the target.
+func (p *pingClient) State() targetState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// recordLatency adds a sample to the jitter window and updates the jitter
+// gauge.
+func (p *pingClient) recordLatency(d time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jitter.add(float64(d) / float64(time.Millisecond))
+	pingJitter.WithLabelValues(p.endpoint).Set(p.jitter.stddev())
+}
+
+// SuccessRate returns the success rate over the recent result window and the
+// number of results it covers.
+func (p *pingClient) SuccessRate() (float64, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.window.successRate()
+}
+
+// Stats returns the cumulative results of the target.
+func (p *pingClient) Stats() targetStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+// WindowCounts returns the number of successful and total results in the
+// recent result window.
+func (p *pingClient) WindowCounts() (successes, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.window.counts()
+}
+
+// clampDuration guards the latency metrics against clock anomalies: a
+// duration that is not positive is counted and observed as zero.
+func clampDuration(d time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	clockAnomalies.Inc()
+	return 0
+}
+
+// ping requests the target once and returns the response status code, or 0
+// when no response was received.
+func (p *pingClient) ping() (int, error) {
+	timeout, cancel := context.WithTimeout(context.Background(), p.timeout)
 	defer cancel()
-	req, err := http.NewRequestWithContext(timeout, http.MethodGet, p.endpoint, nil)
+	if rotateEvery > 0 && p.countPing()%rotateEvery == 0 {
+		// Dropping idle connections makes this ping dial a new one, which a
+		// sticky load balancer may route to a different backend.
+		p.client.CloseIdleConnections()
+	}
+	req, err := http.NewRequestWithContext(timeout, http.MethodGet, p.url, nil)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	if deploymentVersion != "" {
+		req.Header.Set(deploymentHeader, deploymentVersion)
+	}
+	var correlationID string
+	if verifyCorrelation {
+		if correlationID, err = newCorrelationID(); err != nil {
+			return 0, err
+		}
+		req.Header.Set(correlationHeader, correlationID)
+	}
+	var firstByte, connectStart, handshakeStart time.Time
+	if recordTimings || forceNewConn {
+		trace := &httptrace.ClientTrace{
+			GotFirstResponseByte: func() { firstByte = time.Now() },
+			ConnectStart:         func(_, _ string) { connectStart = time.Now() },
+			ConnectDone: func(_, _ string, err error) {
+				if err == nil && forceNewConn {
+					connectDuration.WithLabelValues(p.endpoint).Observe(float64(time.Since(connectStart)) / float64(time.Millisecond))
+				}
+			},
+			TLSHandshakeStart: func() { handshakeStart = time.Now() },
+			TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+				if err == nil && forceNewConn {
+					tlsHandshakeDuration.WithLabelValues(p.endpoint).Observe(float64(time.Since(handshakeStart)) / float64(time.Millisecond))
+				}
+			},
+		}
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
 	}
+	start := time.Now()
 	res, err := p.client.Do(req)
 	if err != nil {
-		return err
+		return 0, err
+	}
+	defer res.Body.Close()
+	p.recordProto(res)
+	if recordHeaders {
+		count, size := headerSize(res.Header)
+		responseHeaderCount.WithLabelValues(p.endpoint).Observe(float64(count))
+		responseHeaderBytes.WithLabelValues(p.endpoint).Observe(float64(size))
+	}
+	if len(cacheHeaders) > 0 {
+		cacheHits.WithLabelValues(p.endpoint, cacheResult(res.Header, cacheHeaders)).Inc()
 	}
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("expected status OK, got %v", res.Status)
+		return res.StatusCode, &pingError{reason: "bad_status", msg: fmt.Sprintf("expected status OK, got %v", res.Status)}
+	}
+	if verifyCorrelation {
+		if err := checkCorrelation(res.Header, correlationHeader, correlationID); err != nil {
+			return res.StatusCode, err
+		}
+	}
+	if len(expectJSONFields) > 0 {
+		err = checkJSONFields(res.Body, expectJSONFields)
+	}
+	if _, cerr := io.Copy(ioutil.Discard, res.Body); err == nil {
+		err = cerr
+	}
+	if recordTimings && err == nil {
+		ttfb.WithLabelValues(p.endpoint).Observe(float64(firstByte.Sub(start)) / float64(time.Millisecond))
+		ttlb.WithLabelValues(p.endpoint).Observe(float64(time.Since(start)) / float64(time.Millisecond))
+	}
+	return res.StatusCode, err
+}
+
+// countPing increments and returns the number of pings started.
+func (p *pingClient) countPing() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pings++
+	return p.pings
+}
+
+// resolve looks up the target hostname and records how long it took and
+// whether it still resolves to the pinged IP. A failed lookup is counted but
+// does not fail the ping, which goes to the already resolved IP.
+func (p *pingClient) resolve() {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	start := time.Now()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, p.host)
+	dnsDuration.WithLabelValues(p.endpoint).Observe(float64(time.Since(start)) / float64(time.Millisecond))
+	if err != nil {
+		dnsErrors.WithLabelValues(p.endpoint).Inc()
+		log.Printf("could not resolve %v for %v: %v\n", p.host, p.endpoint, err)
+		return
+	}
+	ip := endpointIP(p.endpoint)
+	if ip == nil {
+		return
+	}
+	stale := 1.0
+	for _, addr := range addrs {
+		if normalizeIP(addr.IP).Equal(ip) {
+			stale = 0
+			break
+		}
+	}
+	dnsStale.WithLabelValues(p.endpoint).Set(stale)
+}
+
+// headerSize returns the number of header lines in h and their size in bytes
+// as they would appear on the wire ("Key: value\r\n").
+func headerSize(h http.Header) (count, size int) {
+	for k, vs := range h {
+		for _, v := range vs {
+			count++
+			size += len(k) + len(": ") + len(v) + len("\r\n")
+		}
+	}
+	return count, size
+}
+
+// pingError is returned by ping when the target answered but the response
+// did not meet expectations. The reason is a short, stable identifier.
+type pingError struct {
+	reason string
+	msg    string
+}
+
+func (e *pingError) Error() string {
+	return fmt.Sprintf("%s: %s", e.reason, e.msg)
+}
+
+// checkJSONFields decodes body as a JSON object and verifies that all fields
+// are present at the top level.
+func checkJSONFields(body io.Reader, fields []string) error {
+	var obj map[string]json.RawMessage
+	if err := json.NewDecoder(body).Decode(&obj); err != nil {
+		return &pingError{reason: "schema_mismatch", msg: fmt.Sprintf("invalid JSON object: %v", err)}
+	}
+	var missing []string
+	for _, f := range fields {
+		if _, ok := obj[f]; !ok {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return &pingError{reason: "schema_mismatch", msg: fmt.Sprintf("missing fields %v", missing)}
 	}
 	return nil
 }
 
 func pingHandler(w http.ResponseWriter, r *http.Request) {
+	if id := r.Header.Get(correlationHeader); id != "" {
+		w.Header().Set(correlationHeader, id)
+	}
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", pingAllow)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok"))
-	remoteAddr := strings.Split(r.RemoteAddr, ":")
-	pingRequests.WithLabelValues(remoteAddr[0]).Inc()
+	pingRequests.WithLabelValues(remoteIP(r.RemoteAddr)).Inc()
 }
 
-func createPrometheusEndpoint(ctx context.Context) {
+func newPrometheusServer() *http.Server {
 	mux := http.NewServeMux()
 
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", metricsHandler())
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintf(w, "OK")
 	})
+	mux.HandleFunc("/readyz", readyHandler)
+	if envBool("CONSUL_CHECK", false) {
+		mux.HandleFunc("/consulz", consulHandler)
+	}
 
-	srv := &http.Server{
+	return &http.Server{
 		Handler:      mux,
 		Addr:         ":8001",
 		WriteTimeout: 15 * time.Second,
 		ReadTimeout:  15 * time.Second,
 	}
-	go func() {
-		select {
-		case <-ctx.Done():
-			timeout, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-			srv.Shutdown(timeout)
-		}
-	}()
-	srv.ListenAndServe()
 }
@@ -0,0 +1,639 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCheckJSONFields(t *testing.T) {
+	fields := []string{"status", "version"}
+	for _, tc := range []struct {
+		name string
+		body string
+		ok   bool
+	}{
+		{"conforming", `{"status":"ok","version":"1","extra":true}`, true},
+		{"missing field", `{"status":"ok"}`, false},
+		{"nested field only", `{"status":"ok","meta":{"version":"1"}}`, false},
+		{"not an object", `["status","version"]`, false},
+		{"not JSON", `ok`, false},
+		{"empty", ``, false},
+	} {
+		err := checkJSONFields(strings.NewReader(tc.body), fields)
+		if tc.ok {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tc.name, err)
+			}
+			continue
+		}
+		pe, isPingErr := err.(*pingError)
+		if !isPingErr || pe.reason != "schema_mismatch" {
+			t.Errorf("%s: got %v, want a schema_mismatch pingError", tc.name, err)
+		}
+	}
+}
+
+func TestHeaderSize(t *testing.T) {
+	h := http.Header{}
+	h.Set("Content-Type", "text/plain")
+	h.Add("Set-Cookie", "a=1")
+	h.Add("Set-Cookie", "b=2")
+
+	count, size := headerSize(h)
+	if count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+	// "Content-Type: text/plain\r\n" + 2 * "Set-Cookie: a=1\r\n"
+	if want := 26 + 17 + 17; size != want {
+		t.Errorf("size = %d, want %d", size, want)
+	}
+	if count, size := headerSize(http.Header{}); count != 0 || size != 0 {
+		t.Errorf("empty header: got %d, %d, want 0, 0", count, size)
+	}
+}
+
+func TestPingAddrs(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		enabled     bool
+		remoteAddrs string
+		want        []string
+		warn        string
+	}{
+		{"enabled with targets", true, "a,b", []string{"a", "b"}, ""},
+		{"enabled without targets", true, "", nil, "pinging enabled but no targets"},
+		{"disabled with targets", false, "a", nil, "Pinging disabled, ignoring REMOTE_ADDR"},
+		{"disabled without targets", false, "", nil, ""},
+	} {
+		got, warning := pingAddrs(tc.enabled, tc.remoteAddrs)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: addrs = %q, want %q", tc.name, got, tc.want)
+		}
+		if tc.warn == "" && warning != "" || !strings.Contains(warning, tc.warn) {
+			t.Errorf("%s: warning = %q, want it to contain %q", tc.name, warning, tc.warn)
+		}
+	}
+}
+
+func TestClampDuration(t *testing.T) {
+	before := testutil.ToFloat64(clockAnomalies)
+	if got := clampDuration(-time.Millisecond); got != 0 {
+		t.Errorf("negative duration clamped to %v, want 0", got)
+	}
+	if got := clampDuration(0); got != 0 {
+		t.Errorf("zero duration clamped to %v, want 0", got)
+	}
+	if got := clampDuration(time.Millisecond); got != time.Millisecond {
+		t.Errorf("positive duration changed to %v", got)
+	}
+	if n := testutil.ToFloat64(clockAnomalies) - before; n != 2 {
+		t.Errorf("counted %v clock anomalies, want 2", n)
+	}
+}
+
+// newTestPingClient returns an unstarted ping client for the /ping path of
+// srv.
+func newTestPingClient(srv *httptest.Server) *pingClient {
+	return newPingClient(srv.URL+"/ping", targetSpec{host: "127.0.0.1", interval: time.Second, timeout: time.Second})
+}
+
+// waitPings blocks until no ping of p is in flight any more, so that none
+// outlives the test that started it.
+func waitPings(p *pingClient) {
+	for i := 0; i < cap(p.inFlight); i++ {
+		p.inFlight <- struct{}{}
+	}
+	for i := 0; i < cap(p.inFlight); i++ {
+		<-p.inFlight
+	}
+}
+
+func TestPingOverUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "echo.sock")
+	list, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := &httptest.Server{
+		Listener: list,
+		Config:   &http.Server{Handler: http.HandlerFunc(pingHandler)},
+	}
+	srv.Start()
+	defer srv.Close()
+
+	p := newPingClient("unix:"+path, targetSpec{host: "unix:" + path, timeout: time.Second})
+	status, err := p.ping()
+	if err != nil {
+		t.Fatalf("ping over %s: %v", path, err)
+	}
+	if status != http.StatusOK {
+		t.Errorf("status %d, want 200", status)
+	}
+}
+
+func TestSlowTargetSkipsPings(t *testing.T) {
+	var inFlight, maxSeen int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			m := atomic.LoadInt32(&maxSeen)
+			if n <= m || atomic.CompareAndSwapInt32(&maxSeen, m, n) {
+				break
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	p := newTestPingClient(srv)
+	p.interval = 10 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	p.Start(ctx)
+	waitPings(p)
+
+	if skipped := testutil.ToFloat64(skippedPings.WithLabelValues(p.endpoint)); skipped < 5 {
+		t.Errorf("skipped %v pings, want most ticks skipped while the target is slow", skipped)
+	}
+	if m := atomic.LoadInt32(&maxSeen); m != int32(maxInFlight) {
+		t.Errorf("saw %d concurrent pings, want at most PING_MAX_IN_FLIGHT=%d", m, maxInFlight)
+	}
+}
+
+func TestStartClientRefusedPastCap(t *testing.T) {
+	defer func(v int) { maxGoroutines = v }(maxGoroutines)
+	reg := withTargets(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	spec := targetSpec{host: "10.0.0.1", interval: time.Second, timeout: time.Second}
+
+	before := testutil.ToFloat64(refusedClients)
+	maxGoroutines = runtime.NumGoroutine()
+	if startClient(ctx, "http://10.0.0.1:8000/ping", spec) {
+		t.Error("client started past MAX_GOROUTINES")
+	}
+	if n := testutil.ToFloat64(refusedClients) - before; n != 1 {
+		t.Errorf("payments_ping_clients_refused_count moved by %v, want 1", n)
+	}
+	if n := len(reg.Snapshot()); n != 0 {
+		t.Errorf("%d refused clients registered", n)
+	}
+
+	maxGoroutines = runtime.NumGoroutine() + 100
+	if !startClient(ctx, "http://10.0.0.2:8000/ping", spec) {
+		t.Error("client refused below MAX_GOROUTINES")
+	}
+	if n := len(reg.Snapshot()); n != 1 {
+		t.Errorf("%d clients registered, want 1", n)
+	}
+}
+
+func TestStartPingingSkipsUnresolvableHost(t *testing.T) {
+	reg := withTargets(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	spec := targetSpec{interval: time.Second, timeout: time.Second}
+
+	spec.host = "missing.invalid"
+	bad := startPinging(ctx, spec)
+	spec.host = "localhost"
+	good := startPinging(ctx, spec)
+
+	if bad.err == nil || len(bad.endpoints) != 0 {
+		t.Errorf("unresolvable host: got %+v, want an error and no endpoints", bad)
+	}
+	if good.err != nil {
+		t.Fatalf("localhost did not resolve: %v", good.err)
+	}
+	want := []string{"http://127.0.0.1:8000/ping"}
+	if !reflect.DeepEqual(good.endpoints, want) {
+		t.Errorf("localhost endpoints = %v, want %v", good.endpoints, want)
+	}
+	var started []string
+	for _, p := range reg.Snapshot() {
+		started = append(started, p.endpoint)
+	}
+	if !reflect.DeepEqual(started, want) {
+		t.Errorf("started clients %v, want %v", started, want)
+	}
+}
+
+// countingServer returns a server answering pings and a counter of the
+// connections it accepted.
+func countingServer(t *testing.T, h http.HandlerFunc) (*httptest.Server, *int32) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(h)
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+func TestForceNewConn(t *testing.T) {
+	defer func(v bool) { forceNewConn = v }(forceNewConn)
+	for _, force := range []bool{false, true} {
+		forceNewConn = force
+		srv, conns := countingServer(t, pingHandler)
+		p := newTestPingClient(srv)
+		for i := 0; i < 3; i++ {
+			if _, err := p.ping(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want := int32(1)
+		if force {
+			want = 3
+		}
+		if got := atomic.LoadInt32(conns); got != want {
+			t.Errorf("PING_FORCE_NEW_CONN=%v: %d connections for 3 pings, want %d", force, got, want)
+		}
+	}
+}
+
+func TestForceNewConnRecordsTLSHandshake(t *testing.T) {
+	defer func(v bool) { forceNewConn = v }(forceNewConn)
+	forceNewConn = true
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(pingHandler))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	p := newTestPingClient(srv)
+	trustServer(p.client, srv)
+	handshakes, _ := histogram(t, tlsHandshakeDuration.WithLabelValues(p.endpoint))
+	connects, _ := histogram(t, connectDuration.WithLabelValues(p.endpoint))
+	for i := 0; i < 3; i++ {
+		if _, err := p.ping(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := atomic.LoadInt32(&conns); got != 3 {
+		t.Errorf("%d connections for 3 pings, want 3", got)
+	}
+	if n, _ := histogram(t, tlsHandshakeDuration.WithLabelValues(p.endpoint)); n-handshakes != 3 {
+		t.Errorf("%d TLS handshakes recorded for 3 pings, want 3", n-handshakes)
+	}
+	if n, _ := histogram(t, connectDuration.WithLabelValues(p.endpoint)); n-connects != 3 {
+		t.Errorf("%d connects recorded for 3 pings, want 3", n-connects)
+	}
+}
+
+func TestRotateConnections(t *testing.T) {
+	defer func(v int) { rotateEvery = v }(rotateEvery)
+	for _, tt := range []struct {
+		every int
+		want  int32
+	}{
+		{0, 1},
+		{1, 9},
+		{3, 4},
+	} {
+		rotateEvery = tt.every
+		srv, conns := countingServer(t, pingHandler)
+		p := newTestPingClient(srv)
+		for i := 0; i < 9; i++ {
+			if _, err := p.ping(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := atomic.LoadInt32(conns); got != tt.want {
+			t.Errorf("PING_ROTATE_EVERY=%d: %d connections for 9 pings, want %d", tt.every, got, tt.want)
+		}
+	}
+}
+
+func TestDeploymentHeader(t *testing.T) {
+	defer func(v string) { deploymentVersion = v }(deploymentVersion)
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Get(deploymentHeader)
+		pingHandler(w, r)
+	}))
+	defer srv.Close()
+
+	for _, v := range []string{"v1.2.3-canary", ""} {
+		deploymentVersion = v
+		if _, err := newTestPingClient(srv).ping(); err != nil {
+			t.Fatal(err)
+		}
+		if h := <-got; h != v {
+			t.Errorf("target saw %s %q, want %q", deploymentHeader, h, v)
+		}
+	}
+}
+
+func TestLastLatency(t *testing.T) {
+	defer func(v bool) { recordLastLatency = v }(recordLastLatency)
+	lastLatency.Reset()
+	defer lastLatency.Reset()
+	delay := int64(20 * time.Millisecond)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Duration(atomic.LoadInt64(&delay)))
+		pingHandler(w, r)
+	}))
+	defer srv.Close()
+	p := newTestPingClient(srv)
+
+	recordLastLatency = false
+	p.probe()
+	if n := testutil.CollectAndCount(lastLatency, "payments_last_latency_ms"); n != 0 {
+		t.Errorf("%d last latency series without PING_RECORD_LAST_LATENCY", n)
+	}
+
+	recordLastLatency = true
+	p.probe()
+	first := testutil.ToFloat64(lastLatency.WithLabelValues(p.endpoint))
+	if first < 20 || first > 1000 {
+		t.Errorf("last latency %vms for a 20ms ping", first)
+	}
+	atomic.StoreInt64(&delay, 0)
+	p.probe()
+	if got := testutil.ToFloat64(lastLatency.WithLabelValues(p.endpoint)); got >= first {
+		t.Errorf("last latency %vms not replaced by a faster ping, was %vms", got, first)
+	}
+}
+
+func TestAppServerDisableKeepAlive(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		var conns int32
+		srv := httptest.NewUnstartedServer(nil)
+		srv.Config = newAppServer(http.HandlerFunc(pingHandler), 0, disable)
+		srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				atomic.AddInt32(&conns, 1)
+			}
+		}
+		srv.Start()
+		p := newTestPingClient(srv)
+		for i := 0; i < 3; i++ {
+			if _, err := p.ping(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		srv.Close()
+		want := int32(1)
+		if disable {
+			want = 3
+		}
+		if got := atomic.LoadInt32(&conns); got != want {
+			t.Errorf("SERVER_DISABLE_KEEPALIVE=%v: %d connections for 3 pings, want %d", disable, got, want)
+		}
+	}
+}
+
+// temporaryError is an accept error that http.Server.Serve retries.
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "accept: too many open files" }
+func (temporaryError) Timeout() bool   { return false }
+func (temporaryError) Temporary() bool { return true }
+
+// flakyListener fails its first failures accepts with a temporary error.
+type flakyListener struct {
+	net.Listener
+	failures int32
+}
+
+func (l *flakyListener) Accept() (net.Conn, error) {
+	if atomic.AddInt32(&l.failures, -1) >= 0 {
+		return nil, temporaryError{}
+	}
+	return l.Listener.Accept()
+}
+
+func TestServeSurvivesTemporaryAcceptErrors(t *testing.T) {
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flaky := &flakyListener{Listener: list, failures: 3}
+	srv := &http.Server{Handler: http.HandlerFunc(pingHandler), ErrorLog: log.New(ioutil.Discard, "", 0)}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(&proxyHeaderListener{Listener: flaky, timeout: time.Second}) }()
+	defer srv.Close()
+
+	res, err := http.Get("http://" + list.Addr().String() + "/ping")
+	if err != nil {
+		t.Fatalf("ping after temporary accept errors: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("status %d, want 200", res.StatusCode)
+	}
+	select {
+	case err := <-served:
+		t.Fatalf("Serve returned: %v", err)
+	default:
+	}
+	if n := atomic.LoadInt32(&flaky.failures); n >= 0 {
+		t.Errorf("%d accept errors never returned", n+1)
+	}
+}
+
+// histogram returns the sample count and sum of a histogram series.
+func histogram(t *testing.T, o prometheus.Observer) (uint64, float64) {
+	t.Helper()
+	var m dto.Metric
+	if err := o.(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+func TestTimingsOfSlowStream(t *testing.T) {
+	defer func(v bool) { recordTimings = v }(recordTimings)
+	recordTimings = true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("o"))
+		w.(http.Flusher).Flush()
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("k"))
+	}))
+	defer srv.Close()
+
+	p := newTestPingClient(srv)
+	if _, err := p.ping(); err != nil {
+		t.Fatal(err)
+	}
+	nFirst, first := histogram(t, ttfb.WithLabelValues(p.endpoint))
+	nLast, last := histogram(t, ttlb.WithLabelValues(p.endpoint))
+	if nFirst != 1 || nLast != 1 {
+		t.Fatalf("got %d TTFB and %d TTLB samples, want 1 each", nFirst, nLast)
+	}
+	if last-first < 90 {
+		t.Errorf("TTFB %vms, TTLB %vms: want the 100ms body delay between them", first, last)
+	}
+}
+
+func TestNoPingsAfterStopScheduling(t *testing.T) {
+	defer atomic.StoreInt32(&stopped, 0)
+	var pings int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pings, 1)
+	}))
+	defer srv.Close()
+
+	p := newTestPingClient(srv)
+	p.interval = 10 * time.Millisecond
+	done := make(chan struct{})
+	go func() {
+		p.Start(context.Background())
+		close(done)
+	}()
+	time.Sleep(60 * time.Millisecond)
+	stopScheduling()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after stopScheduling")
+	}
+	// Let a ping started just before the stop finish.
+	waitPings(p)
+	n := atomic.LoadInt32(&pings)
+	if n == 0 {
+		t.Fatal("no pings before stopScheduling")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if after := atomic.LoadInt32(&pings); after != n {
+		t.Errorf("%d pings after stopScheduling", after-n)
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	for code, want := range map[int]string{
+		0:   "error",
+		99:  "error",
+		100: "1xx",
+		200: "2xx",
+		204: "2xx",
+		301: "3xx",
+		404: "4xx",
+		499: "4xx",
+		503: "5xx",
+		599: "5xx",
+		600: "error",
+	} {
+		if got := statusClass(code); got != want {
+			t.Errorf("statusClass(%d) = %q, want %q", code, got, want)
+		}
+	}
+}
+
+func TestSinglePortServesAllRoutes(t *testing.T) {
+	srv := httptest.NewServer(newAppMux())
+	defer srv.Close()
+	for path, want := range map[string]string{
+		"/ping":    "ok",
+		"/healthz": "",
+		"/readyz":  "OK",
+		"/version": `"version"`,
+		"/metrics": "payments_targets_down",
+	} {
+		res, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("%s: status %d, want 200", path, res.StatusCode)
+		}
+		if !strings.Contains(string(body), want) {
+			t.Errorf("%s: body does not contain %q", path, want)
+		}
+	}
+}
+
+func TestSLAViolations(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(30 * time.Millisecond)
+		pingHandler(w, r)
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		sla  time.Duration
+		want float64
+	}{
+		{0, 0},
+		{time.Second, 0},
+		{5 * time.Millisecond, 2},
+	} {
+		p := newTestPingClient(srv)
+		p.sla = tt.sla
+		violations := slaViolations.WithLabelValues(p.endpoint)
+		before := testutil.ToFloat64(violations)
+		p.probe()
+		p.probe()
+		if got := testutil.ToFloat64(violations) - before; got != tt.want {
+			t.Errorf("SLA %v: %v violations for two 30ms pings, want %v", tt.sla, got, tt.want)
+		}
+	}
+}
+
+func TestResolveEachCycle(t *testing.T) {
+	defer func(v bool) { resolveEachCycle = v }(resolveEachCycle)
+	resolveEachCycle = true
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	srv := httptest.NewServer(http.HandlerFunc(pingHandler))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		host     string
+		endpoint string
+		errors   float64
+		stale    float64
+	}{
+		{"localhost", srv.URL + "/ping", 0, 0},
+		{"localhost", strings.Replace(srv.URL, "127.0.0.1", "127.0.0.2", 1) + "/ping", 0, 1},
+		{"missing.invalid", srv.URL + "/ping?missing", 3, 0},
+	} {
+		p := newPingClient(tt.endpoint, targetSpec{host: tt.host, interval: time.Second, timeout: time.Second})
+		lookups, _ := histogram(t, dnsDuration.WithLabelValues(p.endpoint))
+		errs := testutil.ToFloat64(dnsErrors.WithLabelValues(p.endpoint))
+		for i := 0; i < 3; i++ {
+			p.probe()
+		}
+		if n, _ := histogram(t, dnsDuration.WithLabelValues(p.endpoint)); n-lookups != 3 {
+			t.Errorf("%s: %d lookups recorded for 3 pings, want 3", tt.host, n-lookups)
+		}
+		if got := testutil.ToFloat64(dnsErrors.WithLabelValues(p.endpoint)) - errs; got != tt.errors {
+			t.Errorf("%s: payments_ping_dns_error_count moved by %v, want %v", tt.host, got, tt.errors)
+		}
+		if got := testutil.ToFloat64(dnsStale.WithLabelValues(p.endpoint)); got != tt.stale {
+			t.Errorf("%s -> %s: payments_ping_dns_stale = %v, want %v", tt.host, tt.endpoint, got, tt.stale)
+		}
+		if tt.host == "missing.invalid" {
+			if s := p.Stats(); s.Successes != 3 {
+				t.Errorf("failed lookups failed pings: %+v", s)
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var methodCompliance = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "payments_ping_method_compliant",
+		Help: "1 if the target handled the HTTP method correctly, 0 otherwise.",
+	},
+	[]string{"endpoint", "method"},
+)
+
+// probeMethods are the extra HTTP methods sent to every target each cycle.
+var probeMethods []string
+
+func init() {
+	registerer.MustRegister(methodCompliance)
+	for _, m := range strings.Split(os.Getenv("PING_PROBE_METHODS"), ",") {
+		switch m = strings.ToUpper(strings.TrimSpace(m)); m {
+		case "":
+		case http.MethodHead, http.MethodOptions:
+			probeMethods = append(probeMethods, m)
+		default:
+			log.Fatalf("invalid PING_PROBE_METHODS entry %q: only HEAD and OPTIONS are supported\n", m)
+		}
+	}
+}
+
+// checkMethods sends every method in probeMethods to the target and records
+// whether it was handled correctly.
+func (p *pingClient) checkMethods() {
+	for _, method := range probeMethods {
+		ok, err := p.checkMethod(method)
+		if err != nil {
+			log.Printf("could not probe %s with %s: %v\n", p.endpoint, method, err)
+		}
+		v := 0.0
+		if ok {
+			v = 1
+		}
+		methodCompliance.WithLabelValues(p.endpoint, method).Set(v)
+	}
+}
+
+func (p *pingClient) checkMethod(method string) (bool, error) {
+	if method == http.MethodHead {
+		return p.checkHead()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, p.url, nil)
+	if err != nil {
+		return false, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	n, err := io.Copy(ioutil.Discard, res.Body)
+	if err != nil {
+		return false, err
+	}
+	return methodCompliant(method, res, n), nil
+}
+
+// checkHead sends HEAD on a connection of its own rather than through the
+// ping client, since http.Client discards any body a target wrongly sends
+// in reply to HEAD.
+func (p *pingClient) checkHead() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, p.url, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Close = true
+	conn, err := p.dialRaw(ctx, req.URL)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if err := req.Write(conn); err != nil {
+		return false, err
+	}
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return false, err
+	}
+	// The target closes the connection after its response, so anything
+	// left to read is a body it should not have sent.
+	n, err := io.Copy(ioutil.Discard, br)
+	if err != nil && !isTimeout(err) {
+		return false, err
+	}
+	return methodCompliant(http.MethodHead, res, n), nil
+}
+
+// dialRaw connects to the target of u the way the ping transport does,
+// including TLS for https.
+func (p *pingClient) dialRaw(ctx context.Context, u *url.URL) (net.Conn, error) {
+	var d net.Dialer
+	if path, ok := unixSocketPath(p.endpoint); ok {
+		return d.DialContext(ctx, "unix", path)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil || u.Scheme != "https" {
+		return conn, err
+	}
+	tc := tls.Client(conn, pingTLSConfig(p.host))
+	if deadline, ok := ctx.Deadline(); ok {
+		tc.SetDeadline(deadline)
+	}
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// methodCompliant reports whether res, with a body of bodyLen bytes, is a
+// correct answer to method: HEAD must succeed without a body and OPTIONS
+// must succeed and list the allowed methods.
+func methodCompliant(method string, res *http.Response, bodyLen int64) bool {
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return false
+	}
+	switch method {
+	case http.MethodHead:
+		return bodyLen == 0
+	case http.MethodOptions:
+		return res.Header.Get("Allow") != ""
+	}
+	return false
+}
+
+// pingAllow lists the methods served by the /ping handler.
+var pingAllow = strings.Join([]string{http.MethodGet, http.MethodHead, http.MethodOptions}, ", ")
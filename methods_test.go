@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCheckMethods(t *testing.T) {
+	defer func(v []string) { probeMethods = v }(probeMethods)
+	probeMethods = []string{http.MethodHead, http.MethodOptions}
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		head    float64
+		options float64
+	}{
+		{"compliant", pingHandler, 1, 1},
+		{"non-compliant", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			pingHandler(w, r)
+		}, 0, 0},
+		{"OPTIONS without Allow", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			pingHandler(w, r)
+		}, 1, 0},
+	} {
+		srv := httptest.NewServer(tt.handler)
+		p := newTestPingClient(srv)
+		p.checkMethods()
+		srv.Close()
+
+		for method, want := range map[string]float64{http.MethodHead: tt.head, http.MethodOptions: tt.options} {
+			if got := testutil.ToFloat64(methodCompliance.WithLabelValues(p.endpoint, method)); got != want {
+				t.Errorf("%s server, %s: payments_ping_method_compliant = %v, want %v", tt.name, method, got, want)
+			}
+		}
+	}
+}
+
+func TestHeadWithBody(t *testing.T) {
+	// A broken target that answers HEAD with the GET body.
+	url := rawServer(t, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok")
+	p := newPingClient(url+"/ping", targetSpec{host: "127.0.0.1", timeout: time.Second})
+	ok, err := p.checkMethod(http.MethodHead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("target sending a body in reply to HEAD reported compliant")
+	}
+}
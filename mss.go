@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var tcpMSSGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "payments_ping_tcp_mss_bytes",
+		Help: "Negotiated TCP maximum segment size of the latest ping connection.",
+	},
+	[]string{"endpoint"},
+)
+
+func init() {
+	registerer.MustRegister(tcpMSSGauge)
+}
+
+// mssDialer returns a DialContext for the ping transport that records the
+// TCP MSS of every new connection to endpoint. A lower MSS than the path
+// should allow hints at MTU or MSS clamping on the way.
+func mssDialer(endpoint string) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	// The same settings http.DefaultTransport dials with.
+	d := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := d.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if mss, ok := tcpMSS(conn); ok {
+			tcpMSSGauge.WithLabelValues(endpoint).Set(float64(mss))
+		}
+		return conn, nil
+	}
+}
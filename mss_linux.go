@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// tcpMSS reads TCP_MAXSEG from the socket of conn.
+func tcpMSS(conn net.Conn) (int, bool) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return 0, false
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return 0, false
+	}
+	var (
+		mss    int
+		sysErr error
+	)
+	if err := raw.Control(func(fd uintptr) {
+		mss, sysErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG)
+	}); err != nil || sysErr != nil {
+		return 0, false
+	}
+	return mss, true
+}
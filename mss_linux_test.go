@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTCPMSSLoopback(t *testing.T) {
+	defer func(v bool) { recordMSS = v }(recordMSS)
+	recordMSS = true
+	srv := httptest.NewServer(http.HandlerFunc(pingHandler))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mss, ok := tcpMSS(conn)
+	conn.Close()
+	// Loopback has a 64KiB MTU, so the MSS is far above the Ethernet 1460.
+	if !ok || mss <= 1460 || mss > 65535 {
+		t.Fatalf("tcpMSS over loopback = %d, %v", mss, ok)
+	}
+	if _, ok := tcpMSS(nil); ok {
+		t.Error("tcpMSS of a non-TCP connection reported ok")
+	}
+
+	p := newTestPingClient(srv)
+	if _, err := p.ping(); err != nil {
+		t.Fatal(err)
+	}
+	if got := testutil.ToFloat64(tcpMSSGauge.WithLabelValues(p.endpoint)); got != float64(mss) {
+		t.Errorf("payments_ping_tcp_mss_bytes = %v, want %d", got, mss)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "net"
+
+// tcpMSS is only implemented for Linux.
+func tcpMSS(conn net.Conn) (int, bool) {
+	return 0, false
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// certPin is the SHA-256 fingerprint that the leaf certificate, or its
+// SubjectPublicKeyInfo, of every TLS target must match. Nil disables pinning.
+var certPin []byte
+
+func init() {
+	v := os.Getenv("PING_CERT_PIN")
+	if v == "" {
+		return
+	}
+	pin, err := hex.DecodeString(strings.ReplaceAll(v, ":", ""))
+	if err != nil || len(pin) != sha256.Size {
+		log.Fatalf("invalid PING_CERT_PIN %q: must be a hex encoded SHA-256 fingerprint\n", v)
+	}
+	certPin = pin
+}
+
+// pingTLSConfig returns the TLS configuration used to probe targets,
+// verifying certificates against serverName and the pinned fingerprint.
+func pingTLSConfig(serverName string) *tls.Config {
+	cfg := &tls.Config{ServerName: serverName}
+	if certPin != nil {
+		cfg.VerifyConnection = verifyPin(certPin)
+	}
+	return cfg
+}
+
+// verifyPin returns a tls.Config.VerifyConnection callback that accepts a
+// connection only when the SHA-256 of the leaf certificate or of its
+// SubjectPublicKeyInfo equals pin.
+func verifyPin(pin []byte) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return &pingError{reason: "cert_pin_mismatch", msg: "no peer certificate"}
+		}
+		leaf := cs.PeerCertificates[0]
+		certSum := sha256.Sum256(leaf.Raw)
+		spkiSum := sha256.Sum256(leaf.RawSubjectPublicKeyInfo)
+		if bytes.Equal(certSum[:], pin) || bytes.Equal(spkiSum[:], pin) {
+			return nil
+		}
+		return &pingError{
+			reason: "cert_pin_mismatch",
+			msg:    fmt.Sprintf("certificate sha256 %x and spki sha256 %x do not match pin %x", certSum, spkiSum, pin),
+		}
+	}
+}
+
+// isPinMismatch reports whether err was caused by a certificate pin mismatch.
+func isPinMismatch(err error) bool {
+	var pe *pingError
+	return errors.As(err, &pe) && pe.reason == "cert_pin_mismatch"
+}
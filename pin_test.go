@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// trustServer makes the TLS transport of c trust the certificate of srv.
+func trustServer(c *http.Client, srv *httptest.Server) {
+	roots := x509.NewCertPool()
+	roots.AddCert(srv.Certificate())
+	c.Transport.(*http.Transport).TLSClientConfig.RootCAs = roots
+}
+
+func TestCertPin(t *testing.T) {
+	defer func(v []byte) { certPin = v }(certPin)
+	srv := httptest.NewTLSServer(http.HandlerFunc(pingHandler))
+	defer srv.Close()
+	cert := srv.Certificate()
+	certSum := sha256.Sum256(cert.Raw)
+	spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	otherSum := sha256.Sum256([]byte("another certificate"))
+
+	for _, tt := range []struct {
+		name string
+		pin  []byte
+		ok   bool
+	}{
+		{"certificate", certSum[:], true},
+		{"public key", spkiSum[:], true},
+		{"mismatch", otherSum[:], false},
+	} {
+		certPin = tt.pin
+		p := newTestPingClient(srv)
+		trustServer(p.client, srv)
+		_, err := p.ping()
+		if tt.ok && err != nil {
+			t.Errorf("%s pin: %v", tt.name, err)
+		}
+		if !tt.ok && !isPinMismatch(err) {
+			t.Errorf("%s pin: err = %v, want a pin mismatch", tt.name, err)
+		}
+	}
+}
+
+func TestRedirectProbePinMismatch(t *testing.T) {
+	defer func(v []byte) { certPin = v }(certPin)
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	plain := httptest.NewServer(http.HandlerFunc(pingHandler))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(pingHandler))
+	defer secure.Close()
+	otherSum := sha256.Sum256([]byte("another certificate"))
+	certPin = otherSum[:]
+
+	endpoint := "redirect-pin-test"
+	r := newTestRedirectProbe(t, plain, secure)
+	pingFailures := testutil.ToFloat64(pingErrors.WithLabelValues(endpoint, "cert_pin_mismatch"))
+	mismatches := testutil.ToFloat64(httpsPinMismatches.WithLabelValues(endpoint))
+	r.check(endpoint, time.Second)
+	if got := testutil.ToFloat64(httpsPinMismatches.WithLabelValues(endpoint)) - mismatches; got != 1 {
+		t.Errorf("payments_ping_https_cert_pin_mismatch_count moved by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(pingErrors.WithLabelValues(endpoint, "cert_pin_mismatch")) - pingFailures; got != 0 {
+		t.Errorf("redirect probe counted %v failed pings", got)
+	}
+	if got := testutil.ToFloat64(httpsUp.WithLabelValues(endpoint)); got != 0 {
+		t.Errorf("payments_ping_https_up = %v with a mismatching pin, want 0", got)
+	}
+}
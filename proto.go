@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	pingProto = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payments_ping_proto",
+			Help: "Set to 1 for the HTTP protocol version of the latest ping response.",
+		},
+		[]string{"endpoint", "proto"},
+	)
+	protoDowngrades = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payments_ping_proto_downgrade_count",
+			Help: "Ping responses using HTTP/1.0 or closing a keep-alive connection.",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+func init() {
+	registerer.MustRegister(pingProto)
+	registerer.MustRegister(protoDowngrades)
+}
+
+// isDowngrade reports whether res was served below HTTP/1.1 or closed the
+// connection although the client expected to keep it alive.
+func isDowngrade(res *http.Response, keepAlive bool) bool {
+	return !res.ProtoAtLeast(1, 1) || (res.Close && keepAlive)
+}
+
+// recordProto updates the protocol metrics of p from res.
+func (p *pingClient) recordProto(res *http.Response) {
+	p.mu.Lock()
+	if p.proto != res.Proto {
+		if p.proto != "" {
+			pingProto.DeleteLabelValues(p.endpoint, p.proto)
+		}
+		p.proto = res.Proto
+		pingProto.WithLabelValues(p.endpoint, p.proto).Set(1)
+	}
+	p.mu.Unlock()
+	if isDowngrade(res, !forceNewConn && !res.Request.Close) {
+		protoDowngrades.WithLabelValues(p.endpoint).Inc()
+	}
+}
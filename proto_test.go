@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestIsDowngrade(t *testing.T) {
+	tests := []struct {
+		name      string
+		major     int
+		minor     int
+		close     bool
+		keepAlive bool
+		want      bool
+	}{
+		{"HTTP/1.0", 1, 0, false, true, true},
+		{"HTTP/1.0 without keep-alive", 1, 0, true, false, true},
+		{"HTTP/1.1", 1, 1, false, true, false},
+		{"HTTP/1.1 closing keep-alive", 1, 1, true, true, true},
+		{"HTTP/1.1 closing without keep-alive", 1, 1, true, false, false},
+		{"HTTP/2", 2, 0, false, true, false},
+	}
+	for _, tt := range tests {
+		res := &http.Response{ProtoMajor: tt.major, ProtoMinor: tt.minor, Close: tt.close}
+		if got := isDowngrade(res, tt.keepAlive); got != tt.want {
+			t.Errorf("%s: isDowngrade = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// rawServer answers every request on a new local listener with response,
+// written verbatim, and closes the connection.
+func rawServer(t *testing.T, response string) string {
+	t.Helper()
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { list.Close() })
+	go func() {
+		for {
+			conn, err := list.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				http.ReadRequest(bufio.NewReader(conn))
+				io.WriteString(conn, response)
+			}()
+		}
+	}()
+	return "http://" + list.Addr().String()
+}
+
+func TestRecordProto(t *testing.T) {
+	http11 := httptest.NewServer(http.HandlerFunc(pingHandler))
+	defer http11.Close()
+	http10 := rawServer(t, "HTTP/1.0 200 OK\r\nContent-Length: 2\r\n\r\nok")
+
+	for _, tt := range []struct {
+		url       string
+		proto     string
+		downgrade float64
+	}{
+		{http11.URL, "HTTP/1.1", 0},
+		{http10, "HTTP/1.0", 1},
+	} {
+		p := newPingClient(tt.url+"/ping", targetSpec{host: "127.0.0.1", timeout: time.Second})
+		before := testutil.ToFloat64(protoDowngrades.WithLabelValues(p.endpoint))
+		if _, err := p.ping(); err != nil {
+			t.Fatalf("%s: %v", tt.proto, err)
+		}
+		if got := testutil.ToFloat64(pingProto.WithLabelValues(p.endpoint, tt.proto)); got != 1 {
+			t.Errorf("%s server: payments_ping_proto{proto=%q} = %v, want 1", tt.proto, tt.proto, got)
+		}
+		if got := testutil.ToFloat64(protoDowngrades.WithLabelValues(p.endpoint)) - before; got != tt.downgrade {
+			t.Errorf("%s server: payments_ping_proto_downgrade_count moved by %v, want %v", tt.proto, got, tt.downgrade)
+		}
+	}
+}
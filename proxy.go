@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pires/go-proxyproto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var malformedProxyHeaders = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "payments_proxy_header_malformed_count",
+		Help: "Connections that sent a malformed or truncated PROXY header, by action taken",
+	},
+	[]string{"action"},
+)
+
+var missingProxyHeaders = prometheus.NewCounter(
+	prometheus.CounterOpts{
+		Name: "payments_proxy_header_missing_count",
+		Help: "Connections rejected for not sending a required PROXY header",
+	},
+)
+
+func init() {
+	registerer.MustRegister(malformedProxyHeaders)
+	registerer.MustRegister(missingProxyHeaders)
+}
+
+// proxyHeaderListener sniffs the first bytes of every accepted connection for
+// the PROXY protocol signature and only parses a header when one is present,
+// so proxied and direct clients can share a port. Without required,
+// connections that do not start with the signature are served as plain TCP
+// with every byte they sent replayed to the server. Connections with a
+// malformed or truncated header are either closed (the default) or, with
+// fallback, served as plain TCP as well.
+type proxyHeaderListener struct {
+	net.Listener
+	required bool
+	fallback bool
+	timeout  time.Duration
+}
+
+func (l *proxyHeaderListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyHeaderConn{Conn: conn, required: l.required, fallback: l.fallback, timeout: l.timeout}, nil
+}
+
+// proxyHeaderConn reads the header lazily on first use so that a slow client
+// only holds up its own connection goroutine and never the accept loop.
+type proxyHeaderConn struct {
+	net.Conn
+	required bool
+	fallback bool
+	timeout  time.Duration
+
+	once   sync.Once
+	reader io.Reader
+	header *proxyproto.Header
+	err    error
+
+	// readDeadline is the last read deadline set by the server, restored
+	// once the header timeout no longer applies.
+	mu           sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *proxyHeaderConn) readHeader() {
+	rec := &recordingReader{r: c.Conn}
+	br := bufio.NewReader(rec)
+	if c.timeout > 0 {
+		deadline := time.Now().Add(c.timeout)
+		c.mu.Lock()
+		if !c.readDeadline.IsZero() && c.readDeadline.Before(deadline) {
+			deadline = c.readDeadline
+		}
+		c.mu.Unlock()
+		c.Conn.SetReadDeadline(deadline)
+		defer func() {
+			c.mu.Lock()
+			c.Conn.SetReadDeadline(c.readDeadline)
+			c.mu.Unlock()
+		}()
+	}
+	header, err := proxyproto.Read(br)
+	switch {
+	case err == nil:
+		c.header = header
+		rest, _ := br.Peek(br.Buffered())
+		c.reader = io.MultiReader(bytes.NewReader(rest), c.Conn)
+	case c.required && (err == proxyproto.ErrNoProxyProtocol || (rec.buf.Len() == 0 && isTimeout(err))):
+		// A client that stayed idle has not sent a header either, which is
+		// not the same as sending a bad one.
+		missingProxyHeaders.Inc()
+		log.Printf("rejecting connection from %v without PROXY header\n", c.Conn.RemoteAddr())
+		c.err = err
+		c.Conn.Close()
+	case err == proxyproto.ErrNoProxyProtocol:
+		c.reader = io.MultiReader(bytes.NewReader(rec.buf.Bytes()), c.Conn)
+	case rec.buf.Len() == 0 && isTimeout(err):
+		// An idle client, such as a pre-opened keep-alive connection, has
+		// sent nothing yet and so cannot have sent a bad header.
+		c.reader = c.Conn
+	case c.fallback:
+		malformedProxyHeaders.WithLabelValues("fallback").Inc()
+		log.Printf("malformed PROXY header from %v, serving as plain connection: %v\n", c.Conn.RemoteAddr(), err)
+		c.reader = io.MultiReader(bytes.NewReader(rec.buf.Bytes()), c.Conn)
+	default:
+		malformedProxyHeaders.WithLabelValues("reject").Inc()
+		log.Printf("rejecting connection from %v with malformed PROXY header: %v\n", c.Conn.RemoteAddr(), err)
+		c.err = err
+		c.Conn.Close()
+	}
+}
+
+func (c *proxyHeaderConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyHeaderConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyHeaderConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyHeaderConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && !c.header.Command.IsLocal() {
+		return c.header.RemoteAddr()
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyHeaderConn) LocalAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.header != nil && !c.header.Command.IsLocal() {
+		return c.header.LocalAddr()
+	}
+	return c.Conn.LocalAddr()
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+// recordingReader keeps a copy of everything read through it so the bytes
+// consumed while looking for a header can be handed back to the server.
+type recordingReader struct {
+	r   io.Reader
+	buf bytes.Buffer
+}
+
+func (r *recordingReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.buf.Write(b[:n])
+	return n, err
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// proxyPipe returns the server side of a pipe wrapped in a proxyHeaderConn
+// and the client side.
+func proxyPipe(t *testing.T, required, fallback bool, timeout time.Duration) (*proxyHeaderConn, net.Conn) {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+	return &proxyHeaderConn{Conn: server, required: required, fallback: fallback, timeout: timeout}, client
+}
+
+func TestProxyHeaderRestoresReadDeadline(t *testing.T) {
+	conn, client := proxyPipe(t, false, false, time.Second)
+	go client.Write([]byte("PROXY TCP4 10.1.2.3 10.0.0.1 4567 8000\r\n"))
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	_, err := conn.Read(make([]byte, 1))
+	if !isTimeout(err) {
+		t.Fatalf("read after the header: err = %v, want a timeout", err)
+	}
+	if took := time.Since(start); took > 500*time.Millisecond {
+		t.Errorf("read timed out after %v, want the server deadline of 100ms restored", took)
+	}
+	if got := conn.RemoteAddr().String(); got != "10.1.2.3:4567" {
+		t.Errorf("RemoteAddr = %s, want the PROXY source 10.1.2.3:4567", got)
+	}
+}
+
+func TestProxyHeaderTruncated(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, fallback := range []bool{false, true} {
+		conn, client := proxyPipe(t, false, fallback, 50*time.Millisecond)
+		go client.Write([]byte("PROXY TCP4 10.1.2.3"))
+
+		action := "reject"
+		if fallback {
+			action = "fallback"
+		}
+		before := testutil.ToFloat64(malformedProxyHeaders.WithLabelValues(action))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		if got := testutil.ToFloat64(malformedProxyHeaders.WithLabelValues(action)) - before; got != 1 {
+			t.Errorf("fallback=%v: %s count moved by %v, want 1", fallback, action, got)
+		}
+		if fallback {
+			if err != nil || string(buf[:n]) != "PROXY TCP4 10.1.2.3" {
+				t.Errorf("fallback: read %q, %v, want the truncated header replayed", buf[:n], err)
+			}
+		} else if err == nil {
+			t.Errorf("reject: read %q without an error", buf[:n])
+		}
+	}
+}
+
+func TestProxyHeaderIdleRequired(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	conn, _ := proxyPipe(t, true, true, 20*time.Millisecond)
+
+	missing := testutil.ToFloat64(missingProxyHeaders)
+	rejected := testutil.ToFloat64(malformedProxyHeaders.WithLabelValues("reject"))
+	fellBack := testutil.ToFloat64(malformedProxyHeaders.WithLabelValues("fallback"))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Error("idle connection without a required header was served")
+	}
+	if got := testutil.ToFloat64(missingProxyHeaders) - missing; got != 1 {
+		t.Errorf("payments_proxy_header_missing_count moved by %v, want 1", got)
+	}
+	if testutil.ToFloat64(malformedProxyHeaders.WithLabelValues("reject")) != rejected ||
+		testutil.ToFloat64(malformedProxyHeaders.WithLabelValues("fallback")) != fellBack {
+		t.Error("idle connection counted as a malformed header")
+	}
+}
+
+func TestProxyListenerMixedClients(t *testing.T) {
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.RemoteAddr)
+	})}
+	go srv.Serve(&proxyHeaderListener{Listener: list, timeout: time.Second})
+	defer srv.Close()
+
+	get := func(header string) string {
+		t.Helper()
+		conn, err := net.Dial("tcp", list.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "%sGET / HTTP/1.1\r\nHost: echo\r\nConnection: close\r\n\r\n", header)
+		res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		body, _ := ioutil.ReadAll(res.Body)
+		return string(body)
+	}
+	if got := get("PROXY TCP4 10.1.2.3 10.0.0.1 4567 8000\r\n"); got != "10.1.2.3:4567" {
+		t.Errorf("proxied client seen as %s, want 10.1.2.3:4567", got)
+	}
+	if got := get(""); !strings.HasPrefix(got, "127.0.0.1:") {
+		t.Errorf("direct client seen as %s, want 127.0.0.1", got)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	publishedResults = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "payments_ping_results_published_count",
+			Help: "Ping results published to the message broker.",
+		},
+	)
+	droppedResults = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "payments_ping_results_dropped_count",
+			Help: "Ping results dropped because the publish buffer was full or publishing failed.",
+		},
+	)
+)
+
+func init() {
+	registerer.MustRegister(publishedResults)
+	registerer.MustRegister(droppedResults)
+}
+
+// pingResult is the message published for every ping.
+type pingResult struct {
+	Timestamp        time.Time `json:"timestamp"`
+	Endpoint         string    `json:"endpoint"`
+	AvailabilityZone string    `json:"availability_zone"`
+	LatencyMs        float64   `json:"latency_ms"`
+	Status           int       `json:"status"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// resultPublisher publishes ping results asynchronously. Results are queued
+// in a bounded buffer and dropped when it is full so that pinging never
+// blocks on the broker.
+type resultPublisher struct {
+	results chan pingResult
+	publish func([]byte) error
+}
+
+// results is set when ping results are published, i.e. with NATS_URL.
+var results *resultPublisher
+
+func newResultPublisher(size int, publish func([]byte) error) *resultPublisher {
+	return &resultPublisher{
+		results: make(chan pingResult, size),
+		publish: publish,
+	}
+}
+
+// Publish queues r without blocking.
+func (p *resultPublisher) Publish(r pingResult) {
+	select {
+	case p.results <- r:
+	default:
+		droppedResults.Inc()
+	}
+}
+
+// run publishes queued results until ctx is done.
+func (p *resultPublisher) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case r := <-p.results:
+			data, err := json.Marshal(r)
+			if err == nil {
+				err = p.publish(data)
+			}
+			if err != nil {
+				log.Printf("could not publish ping result: %v\n", err)
+				droppedResults.Inc()
+				continue
+			}
+			publishedResults.Inc()
+		}
+	}
+}
+
+// startNATSPublisher connects to the NATS server at url and starts
+// publishing ping results to NATS_SUBJECT, queueing up to buffer of them.
+// The connection is retried in the background rather than failing startup.
+func startNATSPublisher(ctx context.Context, url string, buffer int) (*resultPublisher, error) {
+	subject := os.Getenv("NATS_SUBJECT")
+	if subject == "" {
+		subject = "spike-echo.pings"
+	}
+	nc, err := nats.Connect(url,
+		nats.Name("spike-echo"),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		<-ctx.Done()
+		nc.Flush()
+		nc.Close()
+	}()
+	p := newResultPublisher(buffer, func(data []byte) error {
+		return nc.Publish(subject, data)
+	})
+	go p.run(ctx)
+	log.Printf("Publishing ping results to NATS subject %s\n", subject)
+	return p, nil
+}
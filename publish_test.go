@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestResultPublisher(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	published := make(chan []byte, 10)
+	calls := 0
+	p := newResultPublisher(2, func(data []byte) error {
+		if calls++; calls == 2 {
+			return errors.New("broker unavailable")
+		}
+		published <- data
+		return nil
+	})
+	publishedBefore := testutil.ToFloat64(publishedResults)
+	droppedBefore := testutil.ToFloat64(droppedResults)
+
+	// The buffer holds two results; the third is dropped without blocking.
+	for i := 0; i < 3; i++ {
+		p.Publish(pingResult{Endpoint: "http://10.0.0.1:8000/ping", Status: 200 + i})
+	}
+	if got := testutil.ToFloat64(droppedResults) - droppedBefore; got != 1 {
+		t.Fatalf("%v results dropped with a full buffer, want 1", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.run(ctx)
+	var got pingResult
+	select {
+	case data := <-published:
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("published invalid JSON %q: %v", data, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("nothing published")
+	}
+	if got.Endpoint != "http://10.0.0.1:8000/ping" || got.Status != 200 {
+		t.Errorf("published %+v, want the first result", got)
+	}
+
+	// The second result fails to publish and is dropped as well.
+	deadline := time.Now().Add(time.Second)
+	for testutil.ToFloat64(droppedResults)-droppedBefore < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := testutil.ToFloat64(droppedResults) - droppedBefore; got != 2 {
+		t.Errorf("%v results dropped, want 2", got)
+	}
+	if got := testutil.ToFloat64(publishedResults) - publishedBefore; got != 1 {
+		t.Errorf("%v results published, want 1", got)
+	}
+}
+
+// natsMessage is a message received by natsStub.
+type natsMessage struct {
+	subject string
+	data    []byte
+}
+
+// natsStub speaks just enough of the NATS client protocol to accept one
+// connection and report every message published on it. closed is closed
+// once the client has disconnected.
+func natsStub(t *testing.T) (url string, msgs <-chan natsMessage, closed <-chan struct{}) {
+	t.Helper()
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { list.Close() })
+	received := make(chan natsMessage, 10)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn, err := list.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"stub\",\"version\":\"2.2.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			if len(fields) == 0 {
+				continue
+			}
+			switch strings.ToUpper(fields[0]) {
+			case "PING":
+				io.WriteString(conn, "PONG\r\n")
+			case "PUB":
+				// PUB <subject> [reply-to] <#bytes>
+				n, err := strconv.Atoi(fields[len(fields)-1])
+				if err != nil {
+					return
+				}
+				data := make([]byte, n+2)
+				if _, err := io.ReadFull(r, data); err != nil {
+					return
+				}
+				received <- natsMessage{subject: fields[1], data: data[:n]}
+			}
+		}
+	}()
+	return "nats://" + list.Addr().String(), received, done
+}
+
+func TestNATSPublisher(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	setenv(t, "NATS_SUBJECT", "test.pings")
+	url, msgs, closed := natsStub(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := startNATSPublisher(ctx, url, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		// Let the client disconnect before the stub goes away.
+		cancel()
+		<-closed
+	}()
+	p.Publish(pingResult{Endpoint: "http://10.0.0.1:8000/ping", Status: 200})
+
+	select {
+	case m := <-msgs:
+		if m.subject != "test.pings" {
+			t.Errorf("published to %q, want test.pings", m.subject)
+		}
+		var got pingResult
+		if err := json.Unmarshal(m.data, &got); err != nil {
+			t.Fatalf("published invalid JSON %q: %v", m.data, err)
+		}
+		if got.Endpoint != "http://10.0.0.1:8000/ping" || got.Status != 200 {
+			t.Errorf("published %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing published")
+	}
+}
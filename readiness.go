@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// errorRateThreshold is the aggregate ping error rate above which the
+// instance reports not ready. Zero disables the check.
+var errorRateThreshold float64
+
+func init() {
+	v := os.Getenv("ERROR_RATE_READINESS_THRESHOLD")
+	if v == "" {
+		return
+	}
+	t, err := strconv.ParseFloat(v, 64)
+	if err != nil || t < 0 || t > 1 {
+		log.Fatalf("invalid ERROR_RATE_READINESS_THRESHOLD %q: must be between 0 and 1\n", v)
+	}
+	errorRateThreshold = t
+}
+
+// aggregateErrorRate returns the error rate over the recent result windows
+// of all targets and the number of results it is based on.
+func aggregateErrorRate() (float64, int) {
+	var successes, total int
+	for _, p := range targets.Snapshot() {
+		s, n := p.WindowCounts()
+		successes += s
+		total += n
+	}
+	if total == 0 {
+		return 0, 0
+	}
+	return float64(total-successes) / float64(total), total
+}
+
+// readyHandler reports whether the instance should receive traffic. It
+// fails once the aggregate ping error rate exceeds the configured threshold.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+	if errorRateThreshold > 0 {
+		if rate, n := aggregateErrorRate(); n > 0 && rate > errorRateThreshold {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintf(w, "not ready: ping error rate %.2f exceeds %.2f over %d results\n", rate, errorRateThreshold, n)
+			return
+		}
+	}
+	fmt.Fprintf(w, "OK")
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadinessFlipsOnErrorRate(t *testing.T) {
+	defer func(v float64) { errorRateThreshold = v }(errorRateThreshold)
+	errorRateThreshold = 0.5
+	p := newTestClient("http://10.0.0.1:8000/ping", statePending)
+	withTargets(t).Add(p)
+
+	ready := func() int {
+		rec := httptest.NewRecorder()
+		readyHandler(rec, httptest.NewRequest("GET", "/readyz", nil))
+		return rec.Code
+	}
+	if code := ready(); code != 200 {
+		t.Fatalf("no results yet: status %d, want 200", code)
+	}
+
+	p.recordResult(nil)
+	p.recordResult(errors.New("boom"))
+	if code := ready(); code != 200 {
+		t.Fatalf("error rate 0.5: status %d, want 200", code)
+	}
+	p.recordResult(errors.New("boom"))
+	if code := ready(); code != 503 {
+		t.Fatalf("error rate 0.67: status %d, want 503", code)
+	}
+	for i := 0; i < 3; i++ {
+		p.recordResult(nil)
+	}
+	if code := ready(); code != 200 {
+		t.Fatalf("error rate 0.33: status %d, want 200", code)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	httpsRedirect = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payments_ping_https_redirect",
+			Help: "1 if the plain HTTP endpoint redirects to HTTPS, 0 if it serves plaintext.",
+		},
+		[]string{"endpoint"},
+	)
+	httpsUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "payments_ping_https_up",
+			Help: "1 if the HTTPS endpoint answered without a client or server error.",
+		},
+		[]string{"endpoint"},
+	)
+	httpsPinMismatches = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "payments_ping_https_cert_pin_mismatch_count",
+			Help: "HTTPS redirect probes whose certificate did not match PING_CERT_PIN.",
+		},
+		[]string{"endpoint"},
+	)
+)
+
+var (
+	// checkHTTPSRedirect enables probing targets over both HTTP and HTTPS.
+	checkHTTPSRedirect bool
+	// httpsPort is the port probed for HTTPS.
+	httpsPort = "443"
+)
+
+func init() {
+	registerer.MustRegister(httpsRedirect)
+	registerer.MustRegister(httpsUp)
+	registerer.MustRegister(httpsPinMismatches)
+	checkHTTPSRedirect = envBool("PING_CHECK_HTTPS_REDIRECT", false)
+	if v := os.Getenv("PING_HTTPS_PORT"); v != "" {
+		httpsPort = v
+	}
+}
+
+// redirectProbe checks that a target redirects plain HTTP to HTTPS and that
+// its HTTPS endpoint answers.
+type redirectProbe struct {
+	client   *http.Client
+	httpURL  string
+	httpsURL string
+}
+
+// newRedirectProbe returns a probe for httpURL. serverName is used to verify
+// the certificate of the HTTPS endpoint.
+func newRedirectProbe(httpURL, serverName string) (*redirectProbe, error) {
+	u, err := url.Parse(httpURL)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = "https"
+	u.Host = net.JoinHostPort(u.Hostname(), httpsPort)
+	return &redirectProbe{
+		client: &http.Client{
+			Transport: &http.Transport{
+				IdleConnTimeout: time.Minute,
+				TLSClientConfig: pingTLSConfig(serverName),
+			},
+			// Redirects are the subject of the probe, never follow them.
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		httpURL:  httpURL,
+		httpsURL: u.String(),
+	}, nil
+}
+
+// check probes both schemes and records the results for endpoint.
+func (r *redirectProbe) check(endpoint string, timeout time.Duration) {
+	if res, err := r.get(r.httpURL, timeout); err != nil {
+		log.Printf("could not probe %s for HTTPS redirect: %v\n", r.httpURL, err)
+	} else if isHTTPSRedirect(res) {
+		httpsRedirect.WithLabelValues(endpoint).Set(1)
+	} else {
+		log.Printf("%s serves plaintext without redirecting to HTTPS (status %v)\n", r.httpURL, res.Status)
+		httpsRedirect.WithLabelValues(endpoint).Set(0)
+	}
+
+	if res, err := r.get(r.httpsURL, timeout); err != nil {
+		log.Printf("could not probe %s: %v\n", r.httpsURL, err)
+		// Kept apart from pingErrors, which only counts failed pings.
+		if isPinMismatch(err) {
+			httpsPinMismatches.WithLabelValues(endpoint).Inc()
+		}
+		httpsUp.WithLabelValues(endpoint).Set(0)
+	} else if res.StatusCode >= 400 {
+		httpsUp.WithLabelValues(endpoint).Set(0)
+	} else {
+		httpsUp.WithLabelValues(endpoint).Set(1)
+	}
+}
+
+func (r *redirectProbe) get(url string, timeout time.Duration) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	return res, nil
+}
+
+// isHTTPSRedirect reports whether res redirects to an https:// location.
+func isHTTPSRedirect(res *http.Response) bool {
+	if res.StatusCode < 300 || res.StatusCode > 399 {
+		return false
+	}
+	loc, err := res.Location()
+	return err == nil && loc.Scheme == "https"
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// newTestRedirectProbe returns a probe of plain that expects HTTPS on the
+// port of secure and trusts its certificate.
+func newTestRedirectProbe(t *testing.T, plain, secure *httptest.Server) *redirectProbe {
+	t.Helper()
+	defer func(v string) { httpsPort = v }(httpsPort)
+	_, httpsPort, _ = net.SplitHostPort(secure.Listener.Addr().String())
+	r, err := newRedirectProbe(plain.URL+"/ping", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trustServer(r.client, secure)
+	return r
+}
+
+func TestRedirectProbe(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	secure := httptest.NewTLSServer(http.HandlerFunc(pingHandler))
+	defer secure.Close()
+
+	for _, tt := range []struct {
+		name     string
+		handler  http.HandlerFunc
+		redirect float64
+	}{
+		{"redirecting", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "https://"+r.Host+r.URL.Path, http.StatusMovedPermanently)
+		}, 1},
+		{"plaintext", pingHandler, 0},
+		{"redirecting to plain HTTP", func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+		}, 0},
+	} {
+		plain := httptest.NewServer(tt.handler)
+		r := newTestRedirectProbe(t, plain, secure)
+		endpoint := "redirect-test-" + tt.name
+		r.check(endpoint, time.Second)
+		plain.Close()
+
+		if got := testutil.ToFloat64(httpsRedirect.WithLabelValues(endpoint)); got != tt.redirect {
+			t.Errorf("%s: payments_ping_https_redirect = %v, want %v", tt.name, got, tt.redirect)
+		}
+		if got := testutil.ToFloat64(httpsUp.WithLabelValues(endpoint)); got != 1 {
+			t.Errorf("%s: payments_ping_https_up = %v, want 1", tt.name, got)
+		}
+	}
+}
+
+func TestRedirectProbeHTTPSDown(t *testing.T) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	plain := httptest.NewServer(http.HandlerFunc(pingHandler))
+	defer plain.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer secure.Close()
+
+	r := newTestRedirectProbe(t, plain, secure)
+	r.check("redirect-test-down", time.Second)
+	if got := testutil.ToFloat64(httpsUp.WithLabelValues("redirect-test-down")); got != 0 {
+		t.Errorf("payments_ping_https_up = %v for a 503, want 0", got)
+	}
+}
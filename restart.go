@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// listenFDsEnv tells a re-executed child which inherited file
+	// descriptors hold its listeners, as a comma separated list in the order
+	// app, metrics.
+	listenFDsEnv = "SPIKE_ECHO_LISTEN_FDS"
+	// readyFDEnv names the inherited pipe a re-executed child writes to once
+	// it is about to serve, telling the parent it may drain.
+	readyFDEnv = "SPIKE_ECHO_READY_FD"
+)
+
+// listen returns the listener at index inherited from a parent process
+// during a graceful restart, or a new TCP listener on addr.
+func listen(index int, addr string) (net.Listener, error) {
+	fds := strings.Split(os.Getenv(listenFDsEnv), ",")
+	if index >= len(fds) || fds[index] == "" {
+		return net.Listen("tcp", addr)
+	}
+	fd, err := strconv.Atoi(fds[index])
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %v", listenFDsEnv, os.Getenv(listenFDsEnv), err)
+	}
+	f := os.NewFile(uintptr(fd), "listener")
+	defer f.Close()
+	log.Printf("Using listener inherited on fd %d for %s\n", fd, addr)
+	return net.FileListener(f)
+}
+
+// notifyReady tells the parent of a graceful restart that this process is
+// about to serve. It does nothing when the process was not started by a
+// restart.
+func notifyReady() {
+	v := os.Getenv(readyFDEnv)
+	if v == "" {
+		return
+	}
+	os.Unsetenv(readyFDEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s %q: %v\n", readyFDEnv, v, err)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Printf("could not signal readiness to parent: %v\n", err)
+	}
+}
+
+// waitReady waits for a child to signal readiness on r. It fails when the
+// child closes the pipe without doing so, typically because it exited
+// during startup, or when timeout passes first.
+func waitReady(r *os.File, timeout time.Duration) error {
+	r.SetReadDeadline(time.Now().Add(timeout))
+	_, err := r.Read(make([]byte, 1))
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, io.EOF):
+		return errors.New("child exited before it was ready")
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return fmt.Errorf("child not ready within %v", timeout)
+	}
+	return err
+}
+
+// restart re-executes the running binary, handing it the listeners so that
+// it can accept connections on the same sockets while this process drains.
+// It returns once the child has signalled that it is ready to serve; a child
+// that does not do so within timeout is killed and an error is returned.
+func restart(timeout time.Duration, listeners ...net.Listener) (*os.Process, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer ready.Close()
+	defer readyW.Close()
+	files := []*os.File{os.Stdin, os.Stdout, os.Stderr, readyW}
+	var fds []string
+	for _, l := range listeners {
+		tl, ok := l.(*net.TCPListener)
+		if !ok {
+			return nil, fmt.Errorf("cannot pass listener of type %T", l)
+		}
+		f, err := tl.File()
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		fds = append(fds, strconv.Itoa(len(files)))
+		files = append(files, f)
+	}
+
+	var env []string
+	for _, e := range os.Environ() {
+		if !strings.HasPrefix(e, listenFDsEnv+"=") && !strings.HasPrefix(e, readyFDEnv+"=") {
+			env = append(env, e)
+		}
+	}
+	env = append(env, listenFDsEnv+"="+strings.Join(fds, ","), readyFDEnv+"=3")
+
+	proc, err := os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: files,
+	})
+	// Only the child may hold the write end, so that its exit ends the wait.
+	readyW.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := waitReady(ready, timeout); err != nil {
+		proc.Kill()
+		proc.Wait()
+		return nil, err
+	}
+	return proc, nil
+}
+
+// watchRestart re-executes the binary whenever a restart signal arrives and
+// calls shutdown once the child is ready to serve. If the child fails to
+// start, this process keeps serving.
+//
+// The child is a new process, not a replacement of this one: when this
+// process is PID 1 of a container, as with the shipped Dockerfile, the
+// container and with it the child stop once this process has drained. Run
+// the binary under an init process that keeps the container running, or
+// restart the container instead, when using GRACEFUL_RESTART there.
+func watchRestart(shutdown func(), timeout time.Duration, listeners ...net.Listener) {
+	sigs := make(chan os.Signal, 1)
+	if !notifyRestart(sigs) {
+		log.Printf("graceful restart is not supported on this platform\n")
+		return
+	}
+	for range sigs {
+		proc, err := restart(timeout, listeners...)
+		if err != nil {
+			log.Printf("could not restart: %v\n", err)
+			continue
+		}
+		log.Printf("Started pid %d, draining\n", proc.Pid)
+		shutdown()
+		return
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+import "os"
+
+// notifyRestart reports that graceful restarts are unsupported.
+func notifyRestart(c chan<- os.Signal) bool {
+	return false
+}
@@ -0,0 +1,16 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyRestart relays SIGUSR2, which triggers a graceful restart, to c.
+func notifyRestart(c chan<- os.Signal) bool {
+	signal.Notify(c, syscall.SIGUSR2)
+	return true
+}
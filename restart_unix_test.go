@@ -0,0 +1,111 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// restartChildEnv makes the test binary act as a re-executed child.
+const restartChildEnv = "SPIKE_ECHO_TEST_RESTART_CHILD"
+
+// TestRestartChild is the child side of TestRestartKeepsSocket. It serves a
+// single connection on the inherited listener.
+func TestRestartChild(t *testing.T) {
+	switch os.Getenv(restartChildEnv) {
+	case "":
+		t.Skip("only run as the child of TestRestartKeepsSocket")
+	case "fail":
+		// Startup failure before readiness, as with a bad environment.
+		os.Exit(1)
+	}
+	list, err := listen(0, "127.0.0.1:0")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	notifyReady()
+	conn, err := list.Accept()
+	if err != nil {
+		os.Exit(1)
+	}
+	fmt.Fprintf(conn, "child %d\n", os.Getpid())
+	conn.Close()
+	os.Exit(0)
+}
+
+// withChildArgs makes restart re-execute the test binary as the given child.
+func withChildArgs(t *testing.T, mode string) {
+	t.Helper()
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestRestartChild$"}
+	os.Setenv(restartChildEnv, mode)
+	t.Cleanup(func() {
+		os.Args = args
+		os.Unsetenv(restartChildEnv)
+	})
+}
+
+func TestRestartKeepsSocket(t *testing.T) {
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := list.Addr().String()
+	withChildArgs(t, "serve")
+
+	proc, err := restart(10*time.Second, list)
+	if err != nil {
+		t.Fatalf("restart: %v", err)
+	}
+	defer proc.Wait()
+	// The parent stops accepting, as it does when draining; the socket must
+	// stay open in the child.
+	list.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("socket did not survive the re-exec: %v", err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("child %d\n", proc.Pid); line != want {
+		t.Errorf("got %q, want %q", line, want)
+	}
+}
+
+func TestRestartFailsWhenChildDies(t *testing.T) {
+	list, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer list.Close()
+	withChildArgs(t, "fail")
+
+	if _, err := restart(10*time.Second, list); err == nil || !strings.Contains(err.Error(), "exited before") {
+		t.Fatalf("got %v, want an error for the child exiting during startup", err)
+	}
+}
+
+func TestWaitReadyTimeout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if err := waitReady(r, 50*time.Millisecond); err == nil || !strings.Contains(err.Error(), "not ready") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !aix,!darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+// rlimits is not supported on this platform.
+func rlimits() map[string]rlimit {
+	return nil
+}
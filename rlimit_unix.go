@@ -0,0 +1,26 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import "syscall"
+
+// rlimits returns the soft and hard limits of the resources relevant to
+// capacity debugging.
+func rlimits() map[string]rlimit {
+	resources := map[string]int{
+		"nofile": syscall.RLIMIT_NOFILE,
+		"data":   syscall.RLIMIT_DATA,
+		"stack":  syscall.RLIMIT_STACK,
+		"core":   syscall.RLIMIT_CORE,
+	}
+	limits := make(map[string]rlimit, len(resources))
+	for name, resource := range resources {
+		var l syscall.Rlimit
+		if err := syscall.Getrlimit(resource, &l); err != nil {
+			continue
+		}
+		limits[name] = rlimit{Soft: uint64(l.Cur), Hard: uint64(l.Max)}
+	}
+	return limits
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// selfTestPaths are the local endpoints checked by the startup self-test.
+var selfTestPaths = []string{"/ping", "/healthz", "/metrics"}
+
+// runSelfTest requests every path under baseURL concurrently and returns a
+// single error naming each path that did not answer 200 OK within timeout.
+func runSelfTest(ctx context.Context, baseURL string, paths []string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var (
+		mu     sync.Mutex
+		failed []string
+		wg     sync.WaitGroup
+	)
+	for _, path := range paths {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			if err := checkEndpoint(ctx, baseURL+path); err != nil {
+				mu.Lock()
+				failed = append(failed, fmt.Sprintf("%s: %v", path, err))
+				mu.Unlock()
+			}
+		}(path)
+	}
+	wg.Wait()
+
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("self-test failed for %d endpoint(s): %s", len(failed), strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func checkEndpoint(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("expected status OK, got %v", res.Status)
+	}
+	return nil
+}
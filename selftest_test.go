@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSelfTest(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	if err := runSelfTest(context.Background(), srv.URL, []string{"/ok"}, time.Second); err != nil {
+		t.Fatalf("all endpoints healthy: unexpected error: %v", err)
+	}
+
+	err := runSelfTest(context.Background(), srv.URL, []string{"/ok", "/broken", "/missing"}, time.Second)
+	if err == nil {
+		t.Fatal("expected an error for the failing endpoints")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "2 endpoint(s)") {
+		t.Errorf("error %q does not count both failures", msg)
+	}
+	for _, path := range []string{"/broken: ", "/missing: "} {
+		if !strings.Contains(msg, path) {
+			t.Errorf("error %q does not name %s", msg, path)
+		}
+	}
+	if strings.Contains(msg, "/ok") {
+		t.Errorf("error %q names the healthy endpoint", msg)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// hardKillExitCode is the exit status used when graceful shutdown times out
+// and servers had to be closed forcibly.
+const hardKillExitCode = 3
+
+// shutdownServers gracefully shuts down all servers in parallel. Servers that
+// have not drained within timeout are closed forcibly and their names are
+// returned.
+func shutdownServers(servers map[string]*http.Server, timeout time.Duration) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		mu    sync.Mutex
+		stuck []string
+		wg    sync.WaitGroup
+	)
+	for name, srv := range servers {
+		wg.Add(1)
+		go func(name string, srv *http.Server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				mu.Lock()
+				stuck = append(stuck, name)
+				mu.Unlock()
+			}
+		}(name, srv)
+	}
+	wg.Wait()
+	sort.Strings(stuck)
+	return stuck
+}
+
+// persistFinalState saves the target stats to statePath and dumps the
+// metrics to dumpPath when they are set. It runs on every shutdown,
+// including a forced exit, so that the final ping results are not lost.
+func persistFinalState(statePath, dumpPath string) {
+	if statePath != "" {
+		if err := saveState(statePath); err != nil {
+			log.Printf("could not save state to %s: %v\n", statePath, err)
+		}
+	}
+	if dumpPath != "" {
+		if err := dumpMetrics(metricsRegistry, dumpPath); err != nil {
+			log.Printf("could not dump metrics to %s: %v\n", dumpPath, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShutdownServersReportsStuck(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	defer stuck.Close()
+	// Runs before closing the server, which waits for the handler.
+	defer close(release)
+	idle := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer idle.Close()
+
+	go http.Get(stuck.URL)
+	<-entered
+
+	start := time.Now()
+	got := shutdownServers(map[string]*http.Server{
+		"stuck": stuck.Config,
+		"idle":  idle.Config,
+	}, 100*time.Millisecond)
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("shutdown took %v, want it bounded by the timeout", took)
+	}
+	if want := []string{"stuck"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stuck servers = %q, want %q", got, want)
+	}
+}
+
+func TestPersistFinalState(t *testing.T) {
+	p := newTestClient("http://10.0.0.1:8000/ping", statePending)
+	withTargets(t).Add(p)
+	p.recordResult(nil)
+
+	dir := t.TempDir()
+	statePath, dumpPath := filepath.Join(dir, "state.json"), filepath.Join(dir, "metrics.prom")
+	persistFinalState(statePath, dumpPath)
+
+	state, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		t.Fatalf("state not saved: %v", err)
+	}
+	if !strings.Contains(string(state), `"http://10.0.0.1:8000/ping":{"attempts":1,"successes":1,"failures":0}`) {
+		t.Errorf("saved state lacks the target: %s", state)
+	}
+	dump, err := ioutil.ReadFile(dumpPath)
+	if err != nil {
+		t.Fatalf("metrics not dumped: %v", err)
+	}
+	if !strings.Contains(string(dump), "# TYPE payments_targets_down gauge") {
+		t.Errorf("metrics dump lacks payments_targets_down:\n%s", dump)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// targetSpec describes one entry of REMOTE_ADDR. Its syntax is
+//
+//	host[@interval][;to=timeout][;sla=latency]
+//
+// where interval, timeout and latency are Go durations, e.g.
+// "echo@250ms;to=500ms;sla=100ms".
+// A host of the form "unix:/path/to.sock" is probed over that Unix socket
+// instead of being resolved.
+// Omitted values fall back to the global defaults.
+type targetSpec struct {
+	host     string
+	interval time.Duration
+	timeout  time.Duration
+	// sla is the latency above which a ping violates the SLA. Zero disables
+	// SLA tracking.
+	sla time.Duration
+}
+
+// defaultTargetSpec returns the global defaults of PING_INTERVAL,
+// PING_TIMEOUT and PING_SLA, held to the same rules as per-target values.
+func defaultTargetSpec(interval, timeout, sla time.Duration) (targetSpec, error) {
+	switch {
+	case interval <= 0:
+		return targetSpec{}, fmt.Errorf("invalid PING_INTERVAL %v: must be positive", interval)
+	case timeout <= 0:
+		return targetSpec{}, fmt.Errorf("invalid PING_TIMEOUT %v: must be positive", timeout)
+	case sla < 0:
+		return targetSpec{}, fmt.Errorf("invalid PING_SLA %v: must not be negative", sla)
+	}
+	return targetSpec{interval: interval, timeout: timeout, sla: sla}, nil
+}
+
+// parseTargetSpec parses s, taking unspecified values from def.
+func parseTargetSpec(s string, def targetSpec) (targetSpec, error) {
+	spec := def
+	parts := strings.Split(strings.TrimSpace(s), ";")
+	hostPart := parts[0]
+	if i := strings.LastIndex(hostPart, "@"); i >= 0 {
+		interval, err := time.ParseDuration(hostPart[i+1:])
+		if err != nil {
+			return spec, fmt.Errorf("invalid interval in %q: %v", s, err)
+		}
+		if interval <= 0 {
+			return spec, fmt.Errorf("interval in %q must be positive", s)
+		}
+		spec.interval = interval
+		hostPart = hostPart[:i]
+	}
+	if hostPart == "" {
+		return spec, fmt.Errorf("missing host in %q", s)
+	}
+	spec.host = hostPart
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return spec, fmt.Errorf("invalid option %q in %q", opt, s)
+		}
+		switch kv[0] {
+		case "to":
+			timeout, err := time.ParseDuration(kv[1])
+			if err != nil {
+				return spec, fmt.Errorf("invalid timeout in %q: %v", s, err)
+			}
+			if timeout <= 0 {
+				return spec, fmt.Errorf("timeout in %q must be positive", s)
+			}
+			spec.timeout = timeout
+		case "sla":
+			sla, err := time.ParseDuration(kv[1])
+			if err != nil {
+				return spec, fmt.Errorf("invalid sla in %q: %v", s, err)
+			}
+			if sla <= 0 {
+				return spec, fmt.Errorf("sla in %q must be positive", s)
+			}
+			spec.sla = sla
+		default:
+			return spec, fmt.Errorf("unknown option %q in %q", kv[0], s)
+		}
+	}
+	return spec, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTargetSpec(t *testing.T) {
+	def := targetSpec{interval: time.Second, timeout: 10 * time.Second}
+	for _, tc := range []struct {
+		in   string
+		want targetSpec
+	}{
+		{"echo", targetSpec{host: "echo", interval: time.Second, timeout: 10 * time.Second}},
+		{" echo ", targetSpec{host: "echo", interval: time.Second, timeout: 10 * time.Second}},
+		{"echo@250ms", targetSpec{host: "echo", interval: 250 * time.Millisecond, timeout: 10 * time.Second}},
+		{"echo;to=500ms", targetSpec{host: "echo", interval: time.Second, timeout: 500 * time.Millisecond}},
+		{"echo@250ms;to=500ms;sla=100ms", targetSpec{host: "echo", interval: 250 * time.Millisecond, timeout: 500 * time.Millisecond, sla: 100 * time.Millisecond}},
+		{"echo;sla=1s;to=2s", targetSpec{host: "echo", interval: time.Second, timeout: 2 * time.Second, sla: time.Second}},
+		{"unix:/run/echo.sock@2s", targetSpec{host: "unix:/run/echo.sock", interval: 2 * time.Second, timeout: 10 * time.Second}},
+	} {
+		got, err := parseTargetSpec(tc.in, def)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%q: got %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"@1s",
+		"echo@",
+		"echo@soon",
+		"echo@0s",
+		"echo@-1s",
+		"echo;to",
+		"echo;to=never",
+		"echo;to=0s",
+		"echo;sla=-1ms",
+		"echo;retries=3",
+	} {
+		if _, err := parseTargetSpec(bad, def); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestDefaultTargetSpec(t *testing.T) {
+	if _, err := defaultTargetSpec(time.Second, 10*time.Second, 0); err != nil {
+		t.Errorf("valid defaults rejected: %v", err)
+	}
+	for _, bad := range [][3]time.Duration{
+		{0, time.Second, 0},
+		{-time.Second, time.Second, 0},
+		{time.Second, 0, 0},
+		{time.Second, -time.Second, 0},
+		{time.Second, time.Second, -time.Millisecond},
+	} {
+		if _, err := defaultTargetSpec(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("interval %v, timeout %v, sla %v: expected an error", bad[0], bad[1], bad[2])
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var activeGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "payments_active",
+		Help: "1 if this instance is active and pinging, 0 if it is a standby.",
+	},
+)
+
+// active is 1 while ping clients may ping. Instances started with
+// STANDBY=true begin inactive until promoted.
+var active int32 = 1
+
+func init() {
+	registerer.MustRegister(activeGauge)
+	setActive(!envBool("STANDBY", false))
+}
+
+func setActive(a bool) {
+	if a {
+		atomic.StoreInt32(&active, 1)
+		activeGauge.Set(1)
+	} else {
+		atomic.StoreInt32(&active, 0)
+		activeGauge.Set(0)
+	}
+}
+
+func isActive() bool {
+	return atomic.LoadInt32(&active) == 1
+}
+
+// standbyHandler returns a handler that makes the instance active or a
+// standby. It only accepts POST.
+func standbyHandler(activate bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		setActive(activate)
+		state := "standby"
+		if activate {
+			state = "active"
+		}
+		log.Printf("Instance is now %s\n", state)
+		fmt.Fprintln(w, state)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestStandbyRoutesNeedToken(t *testing.T) {
+	defer func(v string) { adminToken = v }(adminToken)
+	adminToken = ""
+	srv := httptest.NewServer(newAppMux())
+	defer srv.Close()
+	for _, path := range []string{"/admin/promote", "/admin/demote"} {
+		res, err := http.Post(srv.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s without ADMIN_TOKEN: status %d, want 404", path, res.StatusCode)
+		}
+	}
+}
+
+func TestPromoteDemote(t *testing.T) {
+	defer setActive(isActive())
+	defer func(v string) { adminToken = v }(adminToken)
+	adminToken = "s3cret"
+	defer auditLog.SetOutput(auditLog.Writer())
+	auditLog.SetOutput(ioutil.Discard)
+	admin := httptest.NewServer(newAppMux())
+	defer admin.Close()
+
+	var pings int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&pings, 1)
+		pingHandler(w, r)
+	}))
+	defer target.Close()
+	p := newTestPingClient(target)
+	p.interval = 5 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		p.Start(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		waitPings(p)
+	}()
+
+	post := func(path string) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, admin.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", path, res.StatusCode)
+		}
+	}
+	pingsDuring := func(d time.Duration) int32 {
+		start := atomic.LoadInt32(&pings)
+		time.Sleep(d)
+		return atomic.LoadInt32(&pings) - start
+	}
+
+	post("/admin/demote")
+	if isActive() || testutil.ToFloat64(activeGauge) != 0 {
+		t.Fatalf("demote left the instance active, payments_active = %v", testutil.ToFloat64(activeGauge))
+	}
+	// Lets a ping that was already in flight finish.
+	time.Sleep(20 * time.Millisecond)
+	if n := pingsDuring(50 * time.Millisecond); n != 0 {
+		t.Errorf("%d pings while a standby", n)
+	}
+
+	post("/admin/promote")
+	if !isActive() || testutil.ToFloat64(activeGauge) != 1 {
+		t.Fatalf("promote left the instance a standby, payments_active = %v", testutil.ToFloat64(activeGauge))
+	}
+	if n := pingsDuring(50 * time.Millisecond); n == 0 {
+		t.Error("no pings after promotion")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// targetStats are the cumulative ping results of a target. With STATE_FILE
+// they survive restarts of the process.
+type targetStats struct {
+	Attempts  uint64 `json:"attempts"`
+	Successes uint64 `json:"successes"`
+	Failures  uint64 `json:"failures"`
+}
+
+// stateFile is the on-disk format of STATE_FILE.
+type stateFile struct {
+	Saved   time.Time              `json:"saved"`
+	Targets map[string]targetStats `json:"targets"`
+}
+
+// restored holds the stats loaded at startup, keyed by endpoint, until the
+// matching ping client is created. Clients may start late, for instance
+// once DNS resolution succeeds, so the map is guarded.
+var restored = struct {
+	sync.Mutex
+	stats map[string]targetStats
+}{stats: make(map[string]targetStats)}
+
+// restoredStats returns and forgets the stats saved for endpoint.
+func restoredStats(endpoint string) targetStats {
+	restored.Lock()
+	defer restored.Unlock()
+	s := restored.stats[endpoint]
+	delete(restored.stats, endpoint)
+	return s
+}
+
+// loadState reads the stats saved at path. A missing file is not an error.
+func loadState(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var f stateFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	restored.Lock()
+	defer restored.Unlock()
+	for endpoint, s := range f.Targets {
+		restored.stats[endpoint] = s
+	}
+	return nil
+}
+
+// saveState writes the stats of every registered target to path. The file is
+// replaced atomically. Stats restored for targets that have not started yet
+// are written back unchanged, so that a slow resolution does not drop them;
+// an entry only leaves restored once its client claims it.
+func saveState(path string) error {
+	f := stateFile{Saved: time.Now().UTC(), Targets: make(map[string]targetStats)}
+	restored.Lock()
+	for endpoint, s := range restored.stats {
+		f.Targets[endpoint] = s
+	}
+	restored.Unlock()
+	for _, p := range targets.Snapshot() {
+		f.Targets[p.endpoint] = p.Stats()
+	}
+	data, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// runStateSaver saves the target stats to path every interval until ctx is
+// done. The final save on shutdown is left to the caller.
+func runStateSaver(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := saveState(path); err != nil {
+				log.Printf("could not save state to %s: %v\n", path, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// withRestored empties the restored stats for the duration of a test.
+func withRestored(t *testing.T) {
+	t.Helper()
+	restored.Lock()
+	prev := restored.stats
+	restored.stats = make(map[string]targetStats)
+	restored.Unlock()
+	t.Cleanup(func() {
+		restored.Lock()
+		restored.stats = prev
+		restored.Unlock()
+	})
+}
+
+func readState(t *testing.T, path string) map[string]targetStats {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f stateFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatalf("invalid state %q: %v", data, err)
+	}
+	return f.Targets
+}
+
+func TestStateSurvivesRestart(t *testing.T) {
+	withRestored(t)
+	path := filepath.Join(t.TempDir(), "state.json")
+	const early, late = "http://10.0.0.1:8000/ping", "http://10.0.0.2:8000/ping"
+
+	// First run: both targets ping and the state is saved on shutdown.
+	reg := withTargets(t)
+	for _, endpoint := range []string{early, late} {
+		p := newTestClient(endpoint, statePending)
+		reg.Add(p)
+		p.recordResult(nil)
+		p.recordResult(errors.New("boom"))
+	}
+	if err := saveState(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Second run: one target starts straight away, the other only after
+	// the first save, as when its host is still retrying DNS.
+	reg = withTargets(t)
+	if err := loadState(path); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestClient(early, statePending)
+	reg.Add(p)
+	if got, want := p.Stats(), (targetStats{Attempts: 2, Successes: 1, Failures: 1}); got != want {
+		t.Errorf("restored stats %+v, want %+v", got, want)
+	}
+	p.recordResult(nil)
+	if err := saveState(path); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]targetStats{
+		early: {Attempts: 3, Successes: 2, Failures: 1},
+		late:  {Attempts: 2, Successes: 1, Failures: 1},
+	}
+	if got := readState(t, path); !reflect.DeepEqual(got, want) {
+		t.Errorf("first save: %+v, want the unstarted target written back: %+v", got, want)
+	}
+
+	q := newTestClient(late, statePending)
+	reg.Add(q)
+	if got := q.Stats(); got != want[late] {
+		t.Errorf("late target restored %+v, want %+v", got, want[late])
+	}
+	q.recordResult(nil)
+	if err := saveState(path); err != nil {
+		t.Fatal(err)
+	}
+	want[late] = targetStats{Attempts: 3, Successes: 2, Failures: 1}
+	if got := readState(t, path); !reflect.DeepEqual(got, want) {
+		t.Errorf("second save: %+v, want %+v", got, want)
+	}
+	restored.Lock()
+	left := len(restored.stats)
+	restored.Unlock()
+	if left != 0 {
+		t.Errorf("%d restored entries left after every client claimed its own", left)
+	}
+}
+
+func TestLoadStateMissingFile(t *testing.T) {
+	withRestored(t)
+	if err := loadState(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("missing state file: %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// startTime is used to report uptime.
+var startTime = time.Now()
+
+var statusTemplate = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><title>spike-echo</title></head>
+<body>
+<h1>spike-echo</h1>
+<table>
+<tr><th>Version</th><td>{{.Version}} ({{.Commit}}{{if .Dirty}}, dirty{{end}})</td></tr>
+<tr><th>Uptime</th><td>{{.Uptime}}</td></tr>
+<tr><th>Availability zone</th><td>{{.AvailabilityZone}}</td></tr>
+<tr><th>Targets</th><td>{{.Up}} up, {{.Down}} down, {{.Pending}} pending</td></tr>
+</table>
+{{if .Targets}}
+<h2>Targets</h2>
+<table>
+{{range .Targets}}<tr><td>{{.Endpoint}}</td><td>{{.State}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+type statusTarget struct {
+	Endpoint string
+	State    string
+}
+
+type statusData struct {
+	Version          string
+	Commit           string
+	Dirty            bool
+	Uptime           time.Duration
+	AvailabilityZone string
+	Up, Down         int
+	Pending          int
+	Targets          []statusTarget
+}
+
+// statusHandler serves a small human readable status page on "/".
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	data := statusData{
+		Version:          version,
+		Commit:           commit,
+		Dirty:            isDirty(),
+		Uptime:           time.Since(startTime).Round(time.Second),
+		AvailabilityZone: availabilityZone,
+	}
+	for _, p := range targets.Snapshot() {
+		state := p.State()
+		switch state {
+		case stateUp:
+			data.Up++
+		case stateDown:
+			data.Down++
+		default:
+			data.Pending++
+		}
+		data.Targets = append(data.Targets, statusTarget{Endpoint: p.endpoint, State: state.String()})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, data); err != nil {
+		log.Printf("could not render status page: %v\n", err)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withTargets replaces the target registry for the duration of a test.
+func withTargets(t *testing.T) *targetRegistry {
+	t.Helper()
+	prev := targets
+	targets = newTargetRegistry()
+	t.Cleanup(func() { targets = prev })
+	return targets
+}
+
+// newTestClient returns a ping client for endpoint in the given state. It is
+// not started.
+func newTestClient(endpoint string, state targetState) *pingClient {
+	p := newPingClient(endpoint, targetSpec{host: "test", interval: time.Second, timeout: time.Second})
+	p.state = state
+	return p
+}
+
+func TestStatusHandler(t *testing.T) {
+	reg := withTargets(t)
+	reg.Add(newTestClient("http://10.0.0.1:8000/ping", stateUp))
+	reg.Add(newTestClient("http://10.0.0.2:8000/ping", stateDown))
+	reg.Add(newTestClient("http://10.0.0.3:8000/ping", statePending))
+
+	rec := httptest.NewRecorder()
+	statusHandler(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != 200 {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"<h1>spike-echo</h1>",
+		version,
+		"1 up, 1 down, 1 pending",
+		"<td>http://10.0.0.1:8000/ping</td><td>up</td>",
+		"<td>http://10.0.0.2:8000/ping</td><td>down</td>",
+		"<td>http://10.0.0.3:8000/ping</td><td>pending</td>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("status page does not contain %q:\n%s", want, body)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	statusHandler(rec, httptest.NewRequest("GET", "/elsewhere", nil))
+	if rec.Code != 404 {
+		t.Errorf("status %d for another path, want 404", rec.Code)
+	}
+}
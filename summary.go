@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// defaultSummaryObjectives are used when SUMMARY_OBJECTIVES is unset.
+var defaultSummaryObjectives = map[float64]float64{0.5: 0.05, 0.9: 0.01, 0.99: 0.001}
+
+// latencySummary records ping latency as per-instance quantiles. It is nil
+// unless ENABLE_SUMMARY is set.
+var latencySummary *prometheus.SummaryVec
+
+func init() {
+	if !envBool("ENABLE_SUMMARY", false) {
+		return
+	}
+	objectives := defaultSummaryObjectives
+	if v := os.Getenv("SUMMARY_OBJECTIVES"); v != "" {
+		var err error
+		if objectives, err = parseObjectives(v); err != nil {
+			log.Fatalf("invalid SUMMARY_OBJECTIVES %q: %v\n", v, err)
+		}
+	}
+	latencySummary = newLatencySummary(objectives)
+	registerer.MustRegister(latencySummary)
+}
+
+// newLatencySummary returns the unregistered latency summary with the given
+// quantile objectives.
+func newLatencySummary(objectives map[float64]float64) *prometheus.SummaryVec {
+	return prometheus.NewSummaryVec(
+		prometheus.SummaryOpts{
+			Name:       "payments_request_duration_summary_ms",
+			Help:       "Payments latency quantiles.",
+			Objectives: objectives,
+		},
+		[]string{"availability_zone", "endpoint"},
+	)
+}
+
+// parseObjectives parses a comma separated list of quantile:error pairs,
+// e.g. "0.5:0.05,0.99:0.001".
+func parseObjectives(s string) (map[float64]float64, error) {
+	objectives := make(map[float64]float64)
+	for _, pair := range strings.Split(s, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("expected quantile:error, got %q", pair)
+		}
+		q, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, err
+		}
+		e, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, err
+		}
+		if q <= 0 || q >= 1 {
+			return nil, fmt.Errorf("quantile %v out of range (0, 1)", q)
+		}
+		if e <= 0 || e >= 1 {
+			return nil, fmt.Errorf("error %v for quantile %v out of range (0, 1)", e, q)
+		}
+		objectives[q] = e
+	}
+	return objectives, nil
+}
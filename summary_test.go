@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseObjectives(t *testing.T) {
+	got, err := parseObjectives("0.5:0.05, 0.99:0.001")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(got) != 2 || got[0.5] != 0.05 || got[0.99] != 0.001 {
+		t.Errorf("got %v", got)
+	}
+
+	for _, bad := range []string{
+		"0.5",
+		"x:0.05",
+		"0.5:x",
+		"0:0.05",
+		"1:0.05",
+		"0.5:0",
+		"0.5:-0.1",
+		"0.5:1",
+	} {
+		if _, err := parseObjectives(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestSummaryQuantilesPopulated(t *testing.T) {
+	objectives, err := parseObjectives("0.5:0.05,0.9:0.01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(v *prometheus.SummaryVec) { latencySummary = v }(latencySummary)
+	latencySummary = newLatencySummary(objectives)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(2 * time.Millisecond)
+		pingHandler(w, r)
+	}))
+	defer srv.Close()
+
+	p := newTestPingClient(srv)
+	for i := 0; i < 10; i++ {
+		p.probe()
+	}
+	var m dto.Metric
+	if err := latencySummary.WithLabelValues(availabilityZone, p.endpoint).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if n := m.GetSummary().GetSampleCount(); n != 10 {
+		t.Errorf("payments_request_duration_summary_ms has %d samples for 10 pings", n)
+	}
+	quantiles := m.GetSummary().GetQuantile()
+	if len(quantiles) != len(objectives) {
+		t.Fatalf("got %d quantiles, want %d", len(quantiles), len(objectives))
+	}
+	for _, q := range quantiles {
+		if _, ok := objectives[q.GetQuantile()]; !ok {
+			t.Errorf("unexpected quantile %v", q.GetQuantile())
+		}
+		if v := q.GetValue(); v < 2 || v > 1000 {
+			t.Errorf("quantile %v = %vms for 2ms pings", q.GetQuantile(), v)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// targetRegistry holds the ping clients started by startPinging, keyed by
+// endpoint. It is safe for concurrent use by the ping setup and the status
+// and admin handlers.
+type targetRegistry struct {
+	mu      sync.RWMutex
+	clients map[string]*pingClient
+}
+
+func newTargetRegistry() *targetRegistry {
+	return &targetRegistry{clients: make(map[string]*pingClient)}
+}
+
+// targets is the registry of all running ping clients.
+var targets = newTargetRegistry()
+
+// Add registers p and reports whether it did. A client already registered
+// for the same endpoint is kept and p is not added.
+func (r *targetRegistry) Add(p *pingClient) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clients[p.endpoint]; ok {
+		return false
+	}
+	r.clients[p.endpoint] = p
+	return true
+}
+
+// Remove unregisters and returns the client for endpoint, if any. Stopping
+// the client is up to the caller.
+func (r *targetRegistry) Remove(endpoint string) (*pingClient, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.clients[endpoint]
+	delete(r.clients, endpoint)
+	return p, ok
+}
+
+// Snapshot returns the registered clients sorted by endpoint.
+func (r *targetRegistry) Snapshot() []*pingClient {
+	r.mu.RLock()
+	clients := make([]*pingClient, 0, len(r.clients))
+	for _, p := range r.clients {
+		clients = append(clients, p)
+	}
+	r.mu.RUnlock()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].endpoint < clients[j].endpoint })
+	return clients
+}
+
+// targetState is the health of a target as seen by its ping client.
+type targetState int
+
+const (
+	statePending targetState = iota
+	stateUp
+	stateDown
+)
+
+func (s targetState) String() string {
+	switch s {
+	case stateUp:
+		return "up"
+	case stateDown:
+		return "down"
+	default:
+		return "pending"
+	}
+}
+
+// resolvedTarget records what startPinging resolved a configured host to.
+type resolvedTarget struct {
+	host      string
+	ips       []net.IP
+	endpoints []string
+	// err is set when the host could not be resolved.
+	err error
+}
+
+// formatResolvedTargets renders the resolved target set as a multi-line
+// block suitable for a single log entry.
+func formatResolvedTargets(resolved []resolvedTarget) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range resolved {
+		n += len(r.endpoints)
+	}
+	fmt.Fprintf(&b, "Resolved %d ping endpoint(s) from %d host(s):\n", n, len(resolved))
+	for _, r := range resolved {
+		if r.err != nil {
+			fmt.Fprintf(&b, "  %s -> unresolved, retrying: %v\n", r.host, r.err)
+			continue
+		}
+		fmt.Fprintf(&b, "  %s -> %v\n", r.host, r.ips)
+		for _, e := range r.endpoints {
+			fmt.Fprintf(&b, "    %s\n", e)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSetStateKeepsTargetsDown(t *testing.T) {
+	base := testutil.ToFloat64(targetsDown)
+	a := newTestClient("http://10.0.0.1:8000/ping", statePending)
+	b := newTestClient("http://10.0.0.2:8000/ping", statePending)
+	down := func() float64 { return testutil.ToFloat64(targetsDown) - base }
+
+	for _, step := range []struct {
+		p     *pingClient
+		state targetState
+		want  float64
+	}{
+		{a, stateDown, 1},
+		{a, stateDown, 1},
+		{b, stateDown, 2},
+		{a, stateUp, 1},
+		{a, stateUp, 1},
+		{b, statePending, 0},
+		{b, stateUp, 0},
+	} {
+		step.p.mu.Lock()
+		step.p.setState(step.state)
+		step.p.mu.Unlock()
+		if got := down(); got != step.want {
+			t.Fatalf("after %s -> %v: payments_targets_down moved by %v, want %v", step.p.endpoint, step.state, got, step.want)
+		}
+	}
+}
+
+func TestFormatResolvedTargets(t *testing.T) {
+	got := formatResolvedTargets([]resolvedTarget{
+		{
+			host:      "echo",
+			ips:       []net.IP{net.ParseIP("10.0.0.1").To4(), net.ParseIP("10.0.0.2").To4()},
+			endpoints: []string{"http://10.0.0.1:8000/ping", "http://10.0.0.2:8000/ping"},
+		},
+		{host: "missing", err: errors.New("no such host")},
+		{host: "unix:/run/echo.sock", endpoints: []string{"unix:/run/echo.sock"}},
+	})
+	want := `Resolved 3 ping endpoint(s) from 3 host(s):
+  echo -> [10.0.0.1 10.0.0.2]
+    http://10.0.0.1:8000/ping
+    http://10.0.0.2:8000/ping
+  missing -> unresolved, retrying: no such host
+  unix:/run/echo.sock -> []
+    unix:/run/echo.sock
+`
+	if got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestRegistryAddRemoveConcurrently(t *testing.T) {
+	reg := newTargetRegistry()
+	endpoints := []string{"http://10.0.0.1:8000/ping", "http://10.0.0.2:8000/ping"}
+	var added int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if reg.Add(newTestClient(endpoints[i%2], statePending)) {
+				atomic.AddInt32(&added, 1)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			reg.Snapshot()
+		}()
+	}
+	wg.Wait()
+	if added != 2 {
+		t.Errorf("%d clients added for 2 endpoints, want 2", added)
+	}
+	if n := len(reg.Snapshot()); n != 2 {
+		t.Errorf("%d clients registered, want 2", n)
+	}
+
+	var removed int32
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if p, ok := reg.Remove(endpoints[i%2]); ok {
+				if p.endpoint != endpoints[i%2] {
+					t.Errorf("Remove(%s) returned the client for %s", endpoints[i%2], p.endpoint)
+				}
+				atomic.AddInt32(&removed, 1)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			reg.Snapshot()
+		}()
+	}
+	wg.Wait()
+	if removed != 2 {
+		t.Errorf("%d clients removed for 2 endpoints, want 2", removed)
+	}
+	if n := len(reg.Snapshot()); n != 0 {
+		t.Errorf("%d clients registered after removing all, want 0", n)
+	}
+}
+
+func TestStartClientSkipsDuplicate(t *testing.T) {
+	reg := withTargets(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	spec := targetSpec{host: "10.0.0.1", interval: time.Second, timeout: time.Second}
+	if !startClient(ctx, "http://10.0.0.1:8000/ping", spec) {
+		t.Fatal("first client not started")
+	}
+	first := reg.Snapshot()[0]
+	if startClient(ctx, "http://10.0.0.1:8000/ping", spec) {
+		t.Error("duplicate client started")
+	}
+	if got := reg.Snapshot(); len(got) != 1 || got[0] != first {
+		t.Errorf("registry changed by a duplicate: %v", got)
+	}
+}
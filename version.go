@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Build information, injected at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.dirty=true".
+var (
+	version = "dev"
+	commit  = "unknown"
+	dirty   = "false"
+)
+
+// deploymentHeader tags outbound pings with the prober's version so that
+// target-side logs can attribute probe traffic to a rollout.
+const deploymentHeader = "X-Deployment-Version"
+
+// deploymentVersion is sent in deploymentHeader. It is set from
+// DEPLOYMENT_VERSION, falling back to the build version, when
+// PING_TAG_DEPLOYMENT is enabled and left empty otherwise.
+var deploymentVersion string
+
+var buildInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "payments_build_info",
+		Help: "Build information of the running binary.",
+	},
+	[]string{"version", "commit", "dirty"},
+)
+
+func init() {
+	registerer.MustRegister(buildInfo)
+	recordBuildInfo()
+	if envBool("PING_TAG_DEPLOYMENT", false) {
+		if deploymentVersion = os.Getenv("DEPLOYMENT_VERSION"); deploymentVersion == "" {
+			deploymentVersion = version
+		}
+	}
+}
+
+// recordBuildInfo sets payments_build_info from the build variables.
+func recordBuildInfo() {
+	buildInfo.Reset()
+	buildInfo.WithLabelValues(version, commit, strconv.FormatBool(isDirty())).Set(1)
+}
+
+// isDirty reports whether the binary was built from a tree with uncommitted
+// changes. Unparseable values are treated as clean.
+func isDirty() bool {
+	d, err := strconv.ParseBool(dirty)
+	return err == nil && d
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Version string `json:"version"`
+		Commit  string `json:"commit"`
+		Dirty   bool   `json:"dirty"`
+	}{version, commit, isDirty()})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDirtyBuild(t *testing.T) {
+	defer func(v, c, d string) {
+		version, commit, dirty = v, c, d
+		recordBuildInfo()
+	}(version, commit, dirty)
+
+	for _, tc := range []struct {
+		dirty string
+		want  bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"", false},
+		{"not-a-bool", false},
+	} {
+		version, commit, dirty = "1.2.3", "abc123", tc.dirty
+		recordBuildInfo()
+
+		rec := httptest.NewRecorder()
+		versionHandler(rec, httptest.NewRequest("GET", "/version", nil))
+		var got struct {
+			Version string `json:"version"`
+			Commit  string `json:"commit"`
+			Dirty   bool   `json:"dirty"`
+		}
+		if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+			t.Fatalf("dirty=%q: decoding /version: %v", tc.dirty, err)
+		}
+		if got.Version != "1.2.3" || got.Commit != "abc123" || got.Dirty != tc.want {
+			t.Errorf("dirty=%q: /version = %+v, want dirty %v", tc.dirty, got, tc.want)
+		}
+
+		label := "false"
+		if tc.want {
+			label = "true"
+		}
+		if v := testutil.ToFloat64(buildInfo.WithLabelValues("1.2.3", "abc123", label)); v != 1 {
+			t.Errorf("dirty=%q: payments_build_info{dirty=%q} = %v, want 1", tc.dirty, label, v)
+		}
+		if n := testutil.CollectAndCount(buildInfo); n != 1 {
+			t.Errorf("dirty=%q: payments_build_info has %d series, want 1", tc.dirty, n)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+// resultWindow is a ring buffer over the most recent ping results. It is
+// not safe for concurrent use.
+type resultWindow struct {
+	results   []bool
+	next      int
+	count     int
+	successes int
+}
+
+func newResultWindow(size int) *resultWindow {
+	if size < 1 {
+		size = 1
+	}
+	return &resultWindow{results: make([]bool, size)}
+}
+
+// add records a result, evicting the oldest one once the window is full.
+func (w *resultWindow) add(ok bool) {
+	if w.count == len(w.results) {
+		if w.results[w.next] {
+			w.successes--
+		}
+	} else {
+		w.count++
+	}
+	w.results[w.next] = ok
+	if ok {
+		w.successes++
+	}
+	w.next = (w.next + 1) % len(w.results)
+}
+
+// counts returns the number of successful results and the total number of
+// results in the window.
+func (w *resultWindow) counts() (successes, total int) {
+	return w.successes, w.count
+}
+
+// successRate returns the fraction of successful results in the window and
+// the number of results it is based on. An empty window has a rate of 0.
+func (w *resultWindow) successRate() (float64, int) {
+	if w.count == 0 {
+		return 0, 0
+	}
+	return float64(w.successes) / float64(w.count), w.count
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestResultWindowRollsOff(t *testing.T) {
+	w := newResultWindow(3)
+	if rate, n := w.successRate(); rate != 0 || n != 0 {
+		t.Fatalf("empty window: got %v over %d", rate, n)
+	}
+	for _, step := range []struct {
+		ok        bool
+		successes int
+		total     int
+	}{
+		{true, 1, 1},
+		{false, 1, 2},
+		{true, 2, 3},
+		// The window is full: each add now evicts the oldest result.
+		{false, 1, 3}, // evicts true
+		{false, 1, 3}, // evicts false
+		{false, 0, 3}, // evicts true
+		{true, 1, 3},  // evicts false
+	} {
+		w.add(step.ok)
+		if s, n := w.counts(); s != step.successes || n != step.total {
+			t.Fatalf("after add(%v): got %d/%d, want %d/%d", step.ok, s, n, step.successes, step.total)
+		}
+	}
+	if rate, n := w.successRate(); n != 3 || rate != 1.0/3 {
+		t.Errorf("success rate %v over %d, want 1/3 over 3", rate, n)
+	}
+}